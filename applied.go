@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// appliedError is returned when a patch is already contained in the
+// packaging repository, e.g. because mergebot is run on a bug whose
+// fix was merged already.
+type appliedError struct {
+	// Commit is the commit which contains the patch, if it could be
+	// identified by its patch id.
+	Commit string
+
+	// Version is the Debian version of the first release containing
+	// Commit, or empty if Commit was not released yet.
+	Version string
+
+	// QuiltPatch is the name of the patch in debian/patches which
+	// contains the changes, if any.
+	QuiltPatch string
+}
+
+func (e *appliedError) Error() string {
+	applied := "already applied"
+	if e.QuiltPatch != "" {
+		applied += " as debian/patches/" + e.QuiltPatch
+	}
+	if e.Commit == "" {
+		if e.QuiltPatch != "" {
+			return applied
+		}
+		return applied + " (the patch applies in reverse)"
+	}
+	if e.Version == "" {
+		return fmt.Sprintf("%s in commit %s (not yet released)", applied, e.Commit)
+	}
+	return fmt.Sprintf("%s in commit %s / version %s", applied, e.Commit, e.Version)
+}
+
+// closeHint returns instructions for closing bug, which the already
+// applied patch fixes. Without a released version, bts close cannot
+// record the fixed version, so the bug should be closed by the upload.
+func (e *appliedError) closeHint(bug string) []string {
+	if e.Version == "" {
+		return []string{
+			"The patch is not part of a released version yet. If the bug is not closed yet, add “Closes: #" + bug + "” to the change in debian/changelog before uploading.",
+		}
+	}
+	return []string{
+		"If the bug is not closed yet, close it using:",
+		fmt.Sprintf("bts close %s %s", bug, e.Version),
+	}
+}
+
+// stripComponent strips the first path component of a file name in a
+// diff header (like patch -p1), without touching /dev/null.
+func stripComponent(name string) string {
+	name = strings.SplitN(name, "\t", 2)[0]
+	if name == "/dev/null" {
+		return name
+	}
+	if idx := strings.Index(name, "/"); idx > -1 {
+		return name[idx+1:]
+	}
+	return name
+}
+
+// normalizeDiff converts a plain unified diff (e.g. generated by diff
+// -u) into the form git uses, so that git patch-id computes the same
+// patch id as for the corresponding commit. Diffs generated by git are
+// returned unmodified.
+func normalizeDiff(data []byte) []byte {
+	if bytes.Contains(data, []byte("\ndiff --git ")) || bytes.HasPrefix(data, []byte("diff --git ")) {
+		return data
+	}
+	var result bytes.Buffer
+	lines := strings.SplitAfter(string(data), "\n")
+	for idx := 0; idx < len(lines); idx++ {
+		line := lines[idx]
+		if !strings.HasPrefix(line, "--- ") || idx+1 == len(lines) || !strings.HasPrefix(lines[idx+1], "+++ ") {
+			result.WriteString(line)
+			continue
+		}
+		oldName := stripComponent(strings.TrimSpace(line[len("--- "):]))
+		newName := stripComponent(strings.TrimSpace(lines[idx+1][len("+++ "):]))
+		name := newName
+		if name == "/dev/null" {
+			name = oldName
+		}
+		prefixed := func(prefix, name string) string {
+			if name == "/dev/null" {
+				return name
+			}
+			return prefix + name
+		}
+		fmt.Fprintf(&result, "diff --git a/%s b/%s\n", name, name)
+		fmt.Fprintf(&result, "--- %s\n", prefixed("a/", oldName))
+		fmt.Fprintf(&result, "+++ %s\n", prefixed("b/", newName))
+		idx++
+	}
+	return result.Bytes()
+}
+
+// patchID returns the stable patch id (see git-patch-id(1)) of data,
+// or "" if data does not contain a diff which git understands.
+func patchID(data []byte) (string, error) {
+	cmd := newCommand("git", "patch-id", "--stable")
+	cmd.Stdin = bytes.NewReader(normalizeDiff(data))
+	output, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.SplitN(string(output), " ", 2)[0], nil
+}
+
+// diffPaths returns the names of the files which the diff in data
+// modifies, including the old names of renamed files.
+func diffPaths(data []byte) []string {
+	var paths []string
+	seen := make(map[string]bool)
+	_, files := parseDiff(data)
+	for _, f := range files {
+		for _, name := range []string{f.OldName, f.NewName} {
+			if name == "" || name == "/dev/null" {
+				continue
+			}
+			if name = stripComponent(name); !seen[name] {
+				seen[name] = true
+				paths = append(paths, name)
+			}
+		}
+	}
+	return paths
+}
+
+// commitWithPatchID returns the commit in the packaging repository’s
+// history whose patch id is id, or "" if there is no such commit. Only
+// commits which touch paths are considered, so that the diffs of
+// unrelated commits need not be generated.
+func commitWithPatchID(id string, paths []string) (string, error) {
+	if len(paths) == 0 {
+		return "", nil
+	}
+	// --full-diff includes the changes to other files, without which
+	// the patch id would differ for commits touching further files.
+	args := append([]string{"log", "--patch", "--full-diff", "--no-merges", "--format=commit %H", "--"}, paths...)
+	history, err := newCommand("git", args...).Output()
+	if err != nil {
+		return "", err
+	}
+	cmd := newCommand("git", "patch-id", "--stable")
+	cmd.Stdin = bytes.NewReader(history)
+	output, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == id {
+			return fields[1], nil
+		}
+	}
+	return "", scanner.Err()
+}
+
+// describeSuffixRe matches the suffix which git describe --contains
+// appends to the tag name, e.g. “~2” or “^0”.
+var describeSuffixRe = regexp.MustCompile(`[~^].*$`)
+
+// releasedIn returns the Debian version of the first release which
+// contains commit, based on the debian/<version> tags created by gbp
+// buildpackage --git-tag, or "" if commit was not released yet.
+func releasedIn(commit string) string {
+	output, err := newCommand("git", "describe", "--contains", "--match", "debian/*", commit).Output()
+	if err != nil {
+		// git describe fails if no tag contains commit.
+		return ""
+	}
+	tag := describeSuffixRe.ReplaceAllString(strings.TrimSpace(string(output)), "")
+	// Undo the mangling of characters which are not allowed in git
+	// tag names, see DEBIAN_TAG in gbp.conf(5).
+	return strings.NewReplacer("%", ":", "_", "~").Replace(strings.TrimPrefix(tag, "debian/"))
+}
+
+// checkApplied returns an *appliedError if p (stored in
+// patchFileName) is already contained in the packaging repository,
+// either as a commit with the same patch id or because it applies in
+// reverse (but not forward).
+func checkApplied(p patch) error {
+	id, err := patchID(p.Data)
+	if err != nil {
+		return err
+	}
+	if id != "" {
+		commit, err := commitWithPatchID(id, diffPaths(p.Data))
+		if err != nil {
+			return err
+		}
+		if commit != "" {
+			return &appliedError{Commit: commit, Version: releasedIn(commit)}
+		}
+	}
+
+	dryRun := func(args ...string) bool {
+		// Unlike --batch, --force does not silently reverse patches
+		// which look like they were applied already.
+		args = append([]string{"-p1", "--force", "--dry-run", "-i", filepath.Join("..", patchFileName)}, args...)
+		return newCommand("patch", args...).Run() == nil
+	}
+	if !dryRun() && dryRun("--reverse") {
+		return &appliedError{}
+	}
+	return nil
+}
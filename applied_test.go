@@ -0,0 +1,148 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/Debian/mergebot/loggedexec"
+)
+
+func TestCheckApplied(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "test-check-applied-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	repo := filepath.Join(tempDir, "repo")
+	if err := exec.Command("cp", "-r", "testdata/minimal-debian-package", repo).Run(); err != nil {
+		t.Fatal(err)
+	}
+	gitIn(t, repo, "init")
+	gitIn(t, repo, "add", ".")
+	gitIn(t, repo, "commit", "-m", "Initial commit")
+	const first = "testdata/series/0001-Declare-Standards-Version-3.9.7.patch"
+	const second = "testdata/series/0002-Add-Homepage-field.patch"
+	abs, err := filepath.Abs(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gitIn(t, repo, "am", abs)
+	gitIn(t, repo, "tag", "-a", "-m", "Debian release 1:1.0-2~bpo8+1", "debian/1%1.0-2_bpo8+1")
+	released := gitIn(t, repo, "rev-parse", "HEAD")
+
+	oldNewCommand := newCommand
+	defer func() { newCommand = oldNewCommand }()
+	newCommand = func(name string, arg ...string) *loggedexec.LoggedCmd {
+		cmd := loggedexec.Command(name, arg...)
+		cmd.Dir = repo
+		return cmd
+	}
+
+	checkApplied := func(path string, data []byte) error {
+		if data == nil {
+			if data, err = ioutil.ReadFile(path); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := ioutil.WriteFile(filepath.Join(tempDir, patchFileName), data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		return checkApplied(patch{Data: data, Filename: filepath.Base(path)})
+	}
+
+	err = checkApplied(first, nil)
+	applied, ok := err.(*appliedError)
+	if !ok {
+		t.Fatalf("Unexpected error: got %v, want an *appliedError", err)
+	}
+	if got, want := applied.Commit, released; got != want {
+		t.Fatalf("Incorrect commit: got %q, want %q", got, want)
+	}
+	if got, want := applied.Version, "1:1.0-2~bpo8+1"; got != want {
+		t.Fatalf("Incorrect version: got %q, want %q", got, want)
+	}
+
+	// The same patch as generated by diff -u has the same patch id.
+	contents, err := ioutil.ReadFile(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain := contents[strings.Index(string(contents), "--- a/"):]
+	plain = regexp.MustCompile(`(?m)^(---|\+\+\+) [ab]/(.*)$`).ReplaceAll(plain, []byte("$1 min-1.0/$2\t2016-07-14 18:19:29.000000000 +0200"))
+	if err, ok := checkApplied("plain.diff", plain).(*appliedError); !ok || err.Commit != released {
+		t.Fatalf("Plain diff not recognized as applied in %q: got %v", released, err)
+	}
+
+	if err := checkApplied(second, nil); err != nil {
+		t.Fatalf("Unexpected error for a patch which is not applied: %v", err)
+	}
+
+	// Applying the patch as part of a larger commit changes its patch
+	// id, but it still applies in reverse.
+	abs, err = filepath.Abs(second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gitIn(t, repo, "apply", abs)
+	if err := ioutil.WriteFile(filepath.Join(repo, "debian", "README.Debian"), []byte("min for Debian\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	gitIn(t, repo, "add", ".")
+	gitIn(t, repo, "commit", "-m", "Add Homepage and README.Debian")
+	err = checkApplied(second, nil)
+	if applied, ok := err.(*appliedError); !ok || applied.Commit != "" {
+		t.Fatalf("Unexpected error: got %v, want an *appliedError without commit", err)
+	}
+}
+
+func TestAppliedCloseHint(t *testing.T) {
+	released := &appliedError{Commit: "0123abc", Version: "1.0-2"}
+	if got, want := released.closeHint("831331")[1], "bts close 831331 1.0-2"; got != want {
+		t.Fatalf("Unexpected close hint: got %q, want %q", got, want)
+	}
+
+	for _, e := range []*appliedError{
+		{Commit: "0123abc"},
+		{},
+	} {
+		for _, line := range e.closeHint("831331") {
+			if strings.HasPrefix(line, "bts close") {
+				t.Errorf("closeHint suggests %q without a released version", line)
+			}
+		}
+	}
+}
+
+func TestDiffPaths(t *testing.T) {
+	const diff = `diff --git a/debian/control b/debian/control
+--- a/debian/control
++++ b/debian/control
+@@ -1 +1 @@
+-Source: min
++Source: max
+diff --git a/README b/README.md
+similarity index 90%
+rename from README
+rename to README.md
+--- a/README
++++ b/README.md
+@@ -1 +1 @@
+-min
++max
+--- /dev/null
++++ min-1.0/debian/README.Debian	2016-07-14 18:19:29.000000000 +0200
+@@ -0,0 +1 @@
++min for Debian
+`
+	want := []string{"debian/control", "README", "README.md", "debian/README.Debian"}
+	if got := diffPaths([]byte(diff)); !reflect.DeepEqual(got, want) {
+		t.Fatalf("diffPaths: got %q, want %q", got, want)
+	}
+}
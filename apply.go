@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// threeWayPatchFileName is the patch against a base version, as
+	// generated by the 3-way strategy of applyPatch.
+	threeWayPatchFileName = "latest-3way.patch"
+
+	// conflictReportFileName is written by applyPatch when all
+	// strategies failed.
+	conflictReportFileName = "conflicts.txt"
+)
+
+// applyStrategy is one way of applying patchFileName to the packaging
+// repository in checkoutDir. baseVersions are the Debian versions
+// against which the patch was presumably written (see
+// patch.BaseVersions).
+type applyStrategy struct {
+	Name  string
+	apply func(checkoutDir string, baseVersions []string) error
+}
+
+// applyStrategies are tried in order until one of them succeeds.
+var applyStrategies = []applyStrategy{
+	{"strict", applyStrict},
+	{"3-way", applyThreeWay},
+	{"fuzzy", applyFuzzy},
+}
+
+// applyAttempt records the outcome of one strategy.
+type applyAttempt struct {
+	Strategy string
+	Err      error
+}
+
+// runPatch applies patchFileName using patch(1) with the specified
+// extra arguments, but only if a dry run succeeds, so that a failing
+// patch does not leave a partially patched tree behind.
+func runPatch(args ...string) error {
+	args = append([]string{"-p1", "--batch", "--forward", "-i", filepath.Join("..", patchFileName)}, args...)
+	if err := newCommand("patch", append(args, "--dry-run")...).Run(); err != nil {
+		return err
+	}
+	return newCommand("patch", args...).Run()
+}
+
+func applyStrict(checkoutDir string, baseVersions []string) error {
+	return runPatch("--fuzz=0")
+}
+
+func applyFuzzy(checkoutDir string, baseVersions []string) error {
+	return runPatch("--fuzz=2", "--ignore-whitespace")
+}
+
+// indexLineRe matches the index line of a git diff, which contains
+// the blob ids of the pre- and post-image, e.g. “index fe3b90c..e64d529 100644”.
+var indexLineRe = regexp.MustCompile(`(?m)^index [0-9a-f]+\.\.[0-9a-f]+`)
+
+// debianTag returns the git tag for version, as created by gbp
+// buildpackage --git-tag, see DEBIAN_TAG in gbp.conf(5).
+func debianTag(version string) string {
+	return "debian/" + strings.NewReplacer(":", "%", "~", "_").Replace(version)
+}
+
+// diffAgainstVersion applies patchFileName to the tagged version of
+// the package in a separate worktree and returns the resulting changes
+// as a git diff, which contains the blob ids required by git apply
+// --3way.
+func diffAgainstVersion(checkoutDir, version string) ([]byte, error) {
+	tag := debianTag(version)
+	if err := newCommand("git", "rev-parse", "--verify", "--quiet", tag+"^{commit}").Run(); err != nil {
+		return nil, fmt.Errorf("tag %q not found", tag)
+	}
+	tempDir := filepath.Dir(checkoutDir)
+	baseDir := filepath.Join(tempDir, "base")
+	if err := newCommand("git", "worktree", "add", "--detach", baseDir, tag).Run(); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := os.RemoveAll(baseDir); err != nil {
+			log.Printf("Could not remove %q: %v", baseDir, err)
+		}
+		if err := newCommand("git", "worktree", "prune").Run(); err != nil {
+			log.Printf("Could not prune worktrees: %v", err)
+		}
+	}()
+
+	inBase := func(name string, arg ...string) error {
+		cmd := newCommand(name, arg...)
+		cmd.Dir = baseDir
+		return cmd.Run()
+	}
+	if err := inBase("patch", "-p1", "--batch", "--forward", "-i", filepath.Join(tempDir, patchFileName)); err != nil {
+		return nil, err
+	}
+	if err := inBase("git", "add", "--all"); err != nil {
+		return nil, err
+	}
+	cmd := newCommand("git", "diff", "--cached", "--binary")
+	cmd.Dir = baseDir
+	return cmd.Output()
+}
+
+// applyThreeWay applies patchFileName using git apply --3way, which
+// merges the changes in case the patch does not apply to the current
+// version, but to the version it was written against. That version is
+// determined by the blob ids in the patch’s index lines or, for
+// patches not generated by git, by trying all baseVersions.
+func applyThreeWay(checkoutDir string, baseVersions []string) error {
+	// Failed merges are undone using resetWorkTree, which would discard
+	// uncommitted changes, e.g. after gbp pq export.
+	status, err := newCommand("git", "status", "--porcelain").Output()
+	if err != nil {
+		return err
+	}
+	if len(status) > 0 {
+		return fmt.Errorf("work tree contains uncommitted changes")
+	}
+
+	tempDir := filepath.Dir(checkoutDir)
+	data, err := ioutil.ReadFile(filepath.Join(tempDir, patchFileName))
+	if err != nil {
+		return err
+	}
+	if indexLineRe.Match(data) {
+		if err := newCommand("git", "apply", "--3way", filepath.Join(tempDir, patchFileName)).Run(); err == nil {
+			return nil
+		}
+		resetWorkTree()
+	}
+
+	if len(baseVersions) == 0 {
+		return fmt.Errorf("no base version known to merge against")
+	}
+	for _, version := range baseVersions {
+		diff, err := diffAgainstVersion(checkoutDir, version)
+		if err != nil {
+			log.Printf("Cannot merge against version %q: %v", version, err)
+			continue
+		}
+		threeWayPath := filepath.Join(tempDir, threeWayPatchFileName)
+		if err := ioutil.WriteFile(threeWayPath, diff, 0600); err != nil {
+			return err
+		}
+		if err := newCommand("git", "apply", "--3way", threeWayPath).Run(); err == nil {
+			log.Printf("Merged the patch against version %q", version)
+			return nil
+		}
+		resetWorkTree()
+	}
+	return fmt.Errorf("could not merge against base versions %v", baseVersions)
+}
+
+// resetWorkTree discards all uncommitted changes (e.g. conflict markers
+// from git apply --3way) before trying the next strategy.
+func resetWorkTree() {
+	if err := newCommand("git", "reset", "--hard").Run(); err != nil {
+		log.Printf("Could not reset repository: %v", err)
+	}
+	if err := newCommand("git", "clean", "-fd").Run(); err != nil {
+		log.Printf("Could not clean repository: %v", err)
+	}
+}
+
+// applyPatch applies patchFileName (written against one of
+// baseVersions) to the packaging repository in checkoutDir, trying
+// each of applyStrategies in turn. If all of them fail, a conflict
+// report is written into the temporary directory.
+func applyPatch(checkoutDir string, baseVersions []string) error {
+	var attempts []applyAttempt
+	for _, strategy := range applyStrategies {
+		err := strategy.apply(checkoutDir, baseVersions)
+		attempts = append(attempts, applyAttempt{Strategy: strategy.Name, Err: err})
+		if err == nil {
+			log.Printf("Applied patch using strategy %q", strategy.Name)
+			return nil
+		}
+		log.Printf("Applying patch using strategy %q failed: %v", strategy.Name, err)
+	}
+
+	reportPath := filepath.Join(filepath.Dir(checkoutDir), conflictReportFileName)
+	if err := writeConflictReport(reportPath, attempts); err != nil {
+		return err
+	}
+	return fmt.Errorf("Patch does not apply using any strategy, see %q", reportPath)
+}
+
+// rejectedHunk is a hunk which patch(1) could not apply.
+type rejectedHunk struct {
+	File string
+
+	// Hunk is the number of the hunk within File, starting at 1.
+	Hunk int
+
+	// Line is the line at which patch(1) tried to apply the hunk, or
+	// 0 in case the file does not exist.
+	Line int
+}
+
+var (
+	patchFileRe   = regexp.MustCompile(`^(?:checking|patching) file (.+)$`)
+	hunkFailedRe  = regexp.MustCompile(`^Hunk #(\d+) FAILED at (\d+)`)
+	missingFileRe = regexp.MustCompile(`^can't find file to patch`)
+)
+
+// parseRejectedHunks parses the output of patch(1) into the hunks which
+// could not be applied.
+func parseRejectedHunks(output []byte) []rejectedHunk {
+	var (
+		result  []rejectedHunk
+		current string
+	)
+	for _, line := range strings.Split(string(output), "\n") {
+		if matches := patchFileRe.FindStringSubmatch(line); matches != nil {
+			current = strings.Trim(matches[1], "'")
+			continue
+		}
+		if missingFileRe.MatchString(line) {
+			result = append(result, rejectedHunk{File: "(file not found)"})
+			continue
+		}
+		if matches := hunkFailedRe.FindStringSubmatch(line); matches != nil {
+			hunk, _ := strconv.Atoi(matches[1])
+			lineNum, _ := strconv.Atoi(matches[2])
+			result = append(result, rejectedHunk{File: current, Hunk: hunk, Line: lineNum})
+		}
+	}
+	return result
+}
+
+// writeConflictReport writes a report listing all attempts and the
+// hunks which do not apply (according to a dry run of patch(1)) to
+// path.
+func writeConflictReport(path string, attempts []applyAttempt) error {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "Strategies:\n")
+	for _, a := range attempts {
+		fmt.Fprintf(&buf, "\t%s: %v\n", a.Strategy, a.Err)
+	}
+
+	// patch exits non-zero because hunks fail, which is expected.
+	output, _ := newCommand("patch", "-p1", "--batch", "--forward", "--dry-run", "-i", filepath.Join("..", patchFileName)).Output()
+	rejected := parseRejectedHunks(output)
+
+	data, err := ioutil.ReadFile(filepath.Join(filepath.Dir(path), patchFileName))
+	if err != nil {
+		return err
+	}
+	_, files := parseDiff(data)
+	hunks := make(map[string][]hunk)
+	for _, f := range files {
+		hunks[f.Name()] = f.Hunks
+	}
+
+	fmt.Fprintf(&buf, "\nRejected hunks (%d):\n", len(rejected))
+	for _, r := range rejected {
+		if r.Hunk == 0 {
+			fmt.Fprintf(&buf, "\n%s\n", r.File)
+			continue
+		}
+		fmt.Fprintf(&buf, "\n%s:%d (hunk #%d)\n", r.File, r.Line, r.Hunk)
+		if h := hunks[r.File]; r.Hunk <= len(h) {
+			buf.WriteString(h[r.Hunk-1].Header)
+			for _, line := range h[r.Hunk-1].Lines {
+				buf.WriteString(line)
+			}
+		}
+	}
+	log.Printf("Wrote conflict report listing %d rejected hunks to %q", len(rejected), path)
+	return ioutil.WriteFile(path, buf.Bytes(), 0644)
+}
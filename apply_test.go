@@ -0,0 +1,189 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const applyTestList = "one\ntwo\nthree\nfour\nfive\nsix\nseven\neight\nnine\nten\n"
+
+// setupApplyRepo creates a repository containing the file “list”,
+// tagged as version 1.0-1, followed by a commit which modifies its
+// third line.
+func setupApplyRepo(t *testing.T, tempDir string) string {
+	repo := setupQuiltRepo(t, tempDir)
+	if err := ioutil.WriteFile(filepath.Join(repo, "list"), []byte(applyTestList), 0644); err != nil {
+		t.Fatal(err)
+	}
+	gitIn(t, repo, "add", "list")
+	gitIn(t, repo, "commit", "-m", "Add list")
+	gitIn(t, repo, "tag", "debian/1.0-1")
+	modified := strings.Replace(applyTestList, "three\n", "THREE\n", 1)
+	if err := ioutil.WriteFile(filepath.Join(repo, "list"), []byte(modified), 0644); err != nil {
+		t.Fatal(err)
+	}
+	gitIn(t, repo, "commit", "-a", "-m", "Capitalize three")
+	return repo
+}
+
+// listPatch returns a patch which replaces “six” by “SIX”, with the
+// specified context lines before and after the change.
+func listPatch(before, after string) []byte {
+	var buf []string
+	buf = append(buf, "--- a/list\n", "+++ b/list\n", "@@ -3,7 +3,7 @@\n")
+	for _, line := range strings.SplitAfter(before, "\n") {
+		if line != "" {
+			buf = append(buf, " "+line)
+		}
+	}
+	buf = append(buf, "-six\n", "+SIX\n")
+	for _, line := range strings.SplitAfter(after, "\n") {
+		if line != "" {
+			buf = append(buf, " "+line)
+		}
+	}
+	return []byte(strings.Join(buf, ""))
+}
+
+func readList(t *testing.T, repo string) string {
+	contents, err := ioutil.ReadFile(filepath.Join(repo, "list"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(contents)
+}
+
+func TestApplyPatch(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "test-apply-patch-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	repo := setupApplyRepo(t, tempDir)
+	defer useRepo(repo)()
+	want := strings.Replace(strings.Replace(applyTestList, "three\n", "THREE\n", 1), "six\n", "SIX\n", 1)
+
+	for _, tc := range []struct {
+		desc string
+		data []byte
+	}{
+		{"strict", listPatch("THREE\nfour\nfive\n", "seven\neight\nnine\n")},
+		{"fuzzy", listPatch("three\nfour\nfive\n", "seven\neight\nnine\n")},
+	} {
+		if err := ioutil.WriteFile(filepath.Join(tempDir, patchFileName), tc.data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := applyPatch(repo, nil); err != nil {
+			t.Fatalf("%s: applyPatch: %v", tc.desc, err)
+		}
+		if got := readList(t, repo); got != want {
+			t.Fatalf("%s: unexpected result: got %q, want %q", tc.desc, got, want)
+		}
+		resetWorkTree()
+	}
+
+	// The context does not match at all, so no strategy succeeds.
+	if err := ioutil.WriteFile(filepath.Join(tempDir, patchFileName), listPatch("3\n4\n5\n", "7\n8\n9\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyPatch(repo, nil); err == nil {
+		t.Fatalf("applyPatch unexpectedly succeeded")
+	}
+	if got, want := readList(t, repo), strings.Replace(applyTestList, "three\n", "THREE\n", 1); got != want {
+		t.Fatalf("Failed applyPatch modified the repository: got %q, want %q", got, want)
+	}
+	report, err := ioutil.ReadFile(filepath.Join(tempDir, conflictReportFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"\tstrict: ",
+		"\t3-way: ",
+		"\tfuzzy: ",
+		"Rejected hunks (1):",
+		"list:3 (hunk #1)\n@@ -3,7 +3,7 @@\n 3\n",
+	} {
+		if !strings.Contains(string(report), want) {
+			t.Fatalf("Conflict report does not contain %q:\n%s", want, report)
+		}
+	}
+}
+
+func TestApplyThreeWay(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "test-apply-three-way-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	repo := setupApplyRepo(t, tempDir)
+	defer useRepo(repo)()
+	// Generate a git diff against version 1.0-1, which contains index
+	// lines referring to the blobs of that version.
+	gitIn(t, repo, "checkout", "-q", "debian/1.0-1", "--", "list")
+	if err := ioutil.WriteFile(filepath.Join(repo, "list"), []byte(strings.Replace(applyTestList, "six\n", "SIX\n", 1)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	gitDiff := gitIn(t, repo, "diff", "debian/1.0-1", "--", "list") + "\n"
+	resetWorkTree()
+
+	want := strings.Replace(strings.Replace(applyTestList, "three\n", "THREE\n", 1), "six\n", "SIX\n", 1)
+	plainDiff := listPatch("three\nfour\nfive\n", "seven\neight\nnine\n")
+
+	for _, tc := range []struct {
+		desc         string
+		data         []byte
+		baseVersions []string
+		wantErr      bool
+	}{
+		{"index lines", []byte(gitDiff), nil, false},
+		{"base version", plainDiff, []string{"0.9-1", "1.0-1"}, false},
+		{"no base version", plainDiff, nil, true},
+		{"unknown base version", plainDiff, []string{"0.9-1"}, true},
+	} {
+		if err := ioutil.WriteFile(filepath.Join(tempDir, patchFileName), tc.data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		err := applyThreeWay(repo, tc.baseVersions)
+		if tc.wantErr {
+			if err == nil {
+				t.Fatalf("%s: applyThreeWay unexpectedly succeeded", tc.desc)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: applyThreeWay: %v", tc.desc, err)
+		}
+		if got := readList(t, repo); got != want {
+			t.Fatalf("%s: unexpected result: got %q, want %q", tc.desc, got, want)
+		}
+		resetWorkTree()
+	}
+
+	if _, err := os.Stat(filepath.Join(tempDir, "base")); !os.IsNotExist(err) {
+		t.Fatalf("Worktree of the base version was not removed: %v", err)
+	}
+}
+
+func TestParseRejectedHunks(t *testing.T) {
+	const output = `checking file debian/control
+checking file list
+Hunk #2 FAILED at 17.
+Hunk #3 FAILED at 40.
+2 out of 3 hunks FAILED
+can't find file to patch at input line 20
+`
+	want := []rejectedHunk{
+		{File: "list", Hunk: 2, Line: 17},
+		{File: "list", Hunk: 3, Line: 40},
+		{File: "(file not found)"},
+	}
+	if got := parseRejectedHunks([]byte(output)); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Unexpected result: got %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	autopkgtestEnabled = flag.Bool("autopkgtest",
+		false,
+		"Run the package’s autopkgtests (debian/tests/control) against the built binary packages.")
+
+	autopkgtestVirtName = flag.String("autopkgtest_virt",
+		"schroot",
+		"Virtualization server for -autopkgtest. One of schroot (the sbuild chroot of -target_suite), null (directly on the host) and podman (see -autopkgtest_image).")
+
+	autopkgtestImage = flag.String("autopkgtest_image",
+		"",
+		"Image for -autopkgtest_virt=podman, as created by autopkgtest-build-podman. Defaults to autopkgtest/debian:<suite>, e.g. autopkgtest/debian:unstable.")
+)
+
+const (
+	autopkgtestDirName         = "autopkgtest"
+	autopkgtestSummaryFileName = "autopkgtest-summary"
+)
+
+// autopkgtestVirt is an autopkgtest virtualization server, see
+// autopkgtest(1).
+type autopkgtestVirt interface {
+	// Args returns the arguments following “--” on the autopkgtest
+	// command line for testing packages of suite.
+	Args(suite string) ([]string, error)
+
+	// Check verifies that the testbed for suite is available, so that
+	// mergebot fails before cloning and merging.
+	Check(suite string) error
+}
+
+var autopkgtestVirts = map[string]autopkgtestVirt{
+	"schroot": schrootVirt{},
+	"null":    nullVirt{},
+	"podman":  podmanVirt{},
+}
+
+// lookupAutopkgtestVirt returns the virtualization server called name.
+func lookupAutopkgtestVirt(name string) (autopkgtestVirt, error) {
+	v, err := lookupChoice("-autopkgtest_virt", autopkgtestVirts, name)
+	if err != nil {
+		return nil, err
+	}
+	return v.(autopkgtestVirt), nil
+}
+
+// schrootVirt tests in the sbuild chroot of the suite, see
+// sbuildChroot.
+type schrootVirt struct{}
+
+func (schrootVirt) Args(suite string) ([]string, error) {
+	chroot, err := sbuildChroot(suite)
+	if err != nil {
+		return nil, err
+	}
+	return []string{"schroot", chroot}, nil
+}
+
+func (schrootVirt) Check(suite string) error {
+	chroot, err := sbuildChroot(suite)
+	if err != nil {
+		return err
+	}
+	return checkChroot(chroot)
+}
+
+// nullVirt tests directly on the host, regardless of the suite.
+type nullVirt struct{}
+
+func (nullVirt) Args(suite string) ([]string, error) {
+	return []string{"null"}, nil
+}
+
+func (nullVirt) Check(suite string) error {
+	log.Printf("Running autopkgtests on the host, whose packages might not match suite %q", suite)
+	return nil
+}
+
+// podmanVirt tests in a podman container, see autopkgtestPodmanImage.
+type podmanVirt struct{}
+
+func autopkgtestPodmanImage(suite string) string {
+	if *autopkgtestImage != "" {
+		return *autopkgtestImage
+	}
+	return "autopkgtest/debian:" + baseSuite(suite)
+}
+
+func (podmanVirt) Args(suite string) ([]string, error) {
+	return []string{"podman", autopkgtestPodmanImage(suite)}, nil
+}
+
+func (podmanVirt) Check(suite string) error {
+	_, err := exec.LookPath("podman")
+	return err
+}
+
+// autopkgtestResult is the result of a single test, as listed in
+// autopkgtest’s summary file, e.g. “command1 FAIL non-zero exit status 1”.
+type autopkgtestResult struct {
+	Name string
+	// Status is e.g. PASS, FAIL or SKIP.
+	Status string
+	Reason string
+}
+
+// parseAutopkgtestSummary parses the contents of autopkgtest’s
+// --summary-file.
+func parseAutopkgtestSummary(contents string) ([]autopkgtestResult, error) {
+	var results []autopkgtestResult
+	scanner := bufio.NewScanner(strings.NewReader(contents))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		results = append(results, autopkgtestResult{
+			Name:   fields[0],
+			Status: fields[1],
+			Reason: strings.Join(fields[2:], " "),
+		})
+	}
+	return results, scanner.Err()
+}
+
+// autopkgtestReport lists the results of the package’s autopkgtests.
+type autopkgtestReport struct {
+	Results []autopkgtestResult
+
+	// LogDir is the directory containing the full autopkgtest log and
+	// the output of the individual tests.
+	LogDir string
+}
+
+// Count returns the number of tests whose status is status.
+func (r autopkgtestReport) Count(status string) int {
+	var n int
+	for _, result := range r.Results {
+		if result.Status == status {
+			n++
+		}
+	}
+	return n
+}
+
+// Lines returns a human-readable summary of the report, followed by
+// the results of the individual tests.
+func (r autopkgtestReport) Lines() []string {
+	lines := []string{fmt.Sprintf("autopkgtest: %d passed, %d failed, %d skipped (full log in %q)",
+		r.Count("PASS"), r.Count("FAIL"), r.Count("SKIP"), r.LogDir)}
+	for _, result := range r.Results {
+		line := fmt.Sprintf("  %s %s", result.Name, result.Status)
+		if result.Reason != "" {
+			line += " " + result.Reason
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// runAutopkgtest runs the autopkgtests of the package in checkoutDir
+// for suite in virt, testing the binary packages of result instead of
+// building the package again. It returns nil if the package has no
+// autopkgtests.
+func runAutopkgtest(tempDir, checkoutDir string, result buildResult, virt autopkgtestVirt, suite string) (*autopkgtestReport, error) {
+	if _, err := os.Stat(filepath.Join(checkoutDir, "debian", "tests", "control")); os.IsNotExist(err) {
+		log.Printf("Not running autopkgtest, the package does not contain debian/tests/control")
+		return nil, nil
+	}
+	virtArgs, err := virt.Args(suite)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &autopkgtestReport{LogDir: filepath.Join(tempDir, autopkgtestDirName)}
+	summaryPath := filepath.Join(tempDir, autopkgtestSummaryFileName)
+	args := []string{
+		"--output-dir=" + report.LogDir,
+		"--summary-file=" + summaryPath,
+	}
+	args = append(args, result.Debs...)
+	// Test the source tree, whose tests match the binary packages.
+	args = append(args, checkoutDir+"/", "--")
+	args = append(args, virtArgs...)
+	// autopkgtest exits with a non-zero status when tests fail or are
+	// skipped, so the error is only returned when the summary does not
+	// contain any results, i.e. autopkgtest itself failed.
+	runErr := newCommand("autopkgtest", args...).Run()
+	contents, err := ioutil.ReadFile(summaryPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	report.Results, err = parseAutopkgtestSummary(string(contents))
+	if err != nil {
+		return nil, err
+	}
+	if len(report.Results) == 0 {
+		if runErr != nil {
+			return nil, runErr
+		}
+		return nil, fmt.Errorf("autopkgtest did not report any test results in %q", summaryPath)
+	}
+	return report, nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseAutopkgtestSummary(t *testing.T) {
+	const contents = `command1             PASS
+smoke                FAIL non-zero exit status 1
+needs-root           SKIP Test requires root but testbed does not provide it
+`
+	got, err := parseAutopkgtestSummary(contents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []autopkgtestResult{
+		{Name: "command1", Status: "PASS"},
+		{Name: "smoke", Status: "FAIL", Reason: "non-zero exit status 1"},
+		{Name: "needs-root", Status: "SKIP", Reason: "Test requires root but testbed does not provide it"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("parseAutopkgtestSummary: got %+v, want %+v", got, want)
+	}
+
+	r := autopkgtestReport{Results: got, LogDir: "/tmp/mergebot-1/autopkgtest"}
+	if got, want := r.Lines()[0], `autopkgtest: 1 passed, 1 failed, 1 skipped (full log in "/tmp/mergebot-1/autopkgtest")`; got != want {
+		t.Fatalf("Lines: got %q, want %q", got, want)
+	}
+}
+
+func TestAutopkgtestVirtArgs(t *testing.T) {
+	for _, tt := range []struct {
+		virt  string
+		suite string
+		want  []string
+	}{
+		{"null", "unstable", []string{"null"}},
+		{"podman", "experimental", []string{"podman", "autopkgtest/debian:unstable"}},
+		{"podman", "bookworm-backports", []string{"podman", "autopkgtest/debian:bookworm"}},
+	} {
+		v, err := lookupAutopkgtestVirt(tt.virt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := v.Args(tt.suite)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s arguments for %q: got %q, want %q", tt.virt, tt.suite, got, tt.want)
+		}
+	}
+
+	if _, err := lookupAutopkgtestVirt("qemu"); err == nil {
+		t.Fatalf("lookupAutopkgtestVirt unexpectedly succeeded for an unknown virtualization server")
+	}
+}
+
+func TestRunAutopkgtest(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "test-run-autopkgtest-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	checkoutDir := filepath.Join(tempDir, "repo")
+	if err := os.MkdirAll(filepath.Join(checkoutDir, "debian"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	result := buildResult{
+		Changes: filepath.Join(tempDir, "export", "min_1.1_amd64.changes"),
+		Debs:    []string{filepath.Join(tempDir, "export", "min_1.1_amd64.deb")},
+	}
+	virt, err := lookupAutopkgtestVirt("null")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := runAutopkgtest(tempDir, checkoutDir, result, virt, "unstable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r != nil {
+		t.Fatalf("runAutopkgtest unexpectedly returned %+v for a package without autopkgtests", r)
+	}
+
+	if err := os.MkdirAll(filepath.Join(checkoutDir, "debian", "tests"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(checkoutDir, "debian", "tests", "control"), []byte("Test-Command: min --version\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Divert autopkgtest with a shell script which writes a summary
+	// file containing a failed test (and exits accordingly) only when
+	// invoked with the built binary package and the source tree.
+	autopkgtestDiversion := `#!/bin/sh
+summary=""
+for arg in "$@"; do
+	case "$arg" in
+	--summary-file=*) summary="${arg#--summary-file=}" ;;
+	esac
+done
+[ "$*" = "--output-dir=` + filepath.Join(tempDir, "autopkgtest") + ` --summary-file=$summary ` + result.Debs[0] + ` ` + checkoutDir + `/ -- null" ] || exit 12
+printf 'command1 PASS\ncommand2 FAIL non-zero exit status 1\n' > "$summary"
+exit 4
+`
+	// The diversion cannot live in tempDir, where autopkgtest’s output
+	// directory is placed.
+	binDir := filepath.Join(tempDir, "bin")
+	if err := os.Mkdir(binDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(binDir, "autopkgtest"), []byte(autopkgtestDiversion), 0755); err != nil {
+		t.Fatal(err)
+	}
+	oldPath := os.Getenv("PATH")
+	defer os.Setenv("PATH", oldPath)
+	os.Setenv("PATH", binDir+":"+oldPath)
+
+	r, err = runAutopkgtest(tempDir, checkoutDir, result, virt, "unstable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &autopkgtestReport{
+		Results: []autopkgtestResult{
+			{Name: "command1", Status: "PASS"},
+			{Name: "command2", Status: "FAIL", Reason: "non-zero exit status 1"},
+		},
+		LogDir: filepath.Join(tempDir, "autopkgtest"),
+	}
+	if !reflect.DeepEqual(r, want) {
+		t.Fatalf("runAutopkgtest: got %+v, want %+v", r, want)
+	}
+}
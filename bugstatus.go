@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/Debian/mergebot/debbugs"
+)
+
+func splitList(list string) []string {
+	var result []string
+	for _, entry := range strings.Split(list, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			result = append(result, entry)
+		}
+	}
+	return result
+}
+
+// binaryToSource returns the source package which builds the
+// specified binary package, according to the apt cache.
+func binaryToSource(binary string) (string, error) {
+	output, err := newCommand("apt-cache", "show", "--no-all-versions", binary).Output()
+	if err != nil {
+		return "", fmt.Errorf("Could not determine source package of binary package %q: %v", binary, err)
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		if !strings.HasPrefix(scanner.Text(), "Source: ") {
+			continue
+		}
+		// Strip the version, if any, e.g. “Source: wit (2.31a-2)”.
+		return strings.Fields(strings.TrimPrefix(scanner.Text(), "Source: "))[0], nil
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	// Binary packages with the same name as their source package do
+	// not carry a Source field.
+	return binary, nil
+}
+
+// sourcePackagesFor returns the source packages against which the
+// specified bug was filed.
+func sourcePackagesFor(client *debbugs.Client, bug int) ([]string, error) {
+	statuses, err := client.GetStatus(bug)
+	if err != nil {
+		return nil, err
+	}
+	status, ok := statuses[bug]
+	if !ok {
+		return nil, fmt.Errorf("Bug #%d not found", bug)
+	}
+
+	sources := make(map[string]bool)
+	if status.Source != "" {
+		for _, source := range splitList(status.Source) {
+			sources[source] = true
+		}
+	} else {
+		for _, pkg := range splitList(status.Package) {
+			if strings.HasPrefix(pkg, "src:") {
+				sources[strings.TrimPrefix(pkg, "src:")] = true
+				continue
+			}
+			source, err := binaryToSource(pkg)
+			if err != nil {
+				return nil, err
+			}
+			sources[source] = true
+		}
+	}
+
+	result := make([]string, 0, len(sources))
+	for source := range sources {
+		result = append(result, source)
+	}
+	sort.Strings(result)
+	if len(result) == 0 {
+		return nil, fmt.Errorf("Could not determine the source package of bug #%d (package %q)", bug, status.Package)
+	}
+	return result, nil
+}
+
+// resolveSourcePackage verifies that the specified bug was filed
+// against sourcePackage. If sourcePackage is empty, the source
+// package is inferred from the bug.
+func resolveSourcePackage(client *debbugs.Client, bug int, sourcePackage string) (string, error) {
+	sources, err := sourcePackagesFor(client, bug)
+	if err != nil {
+		return "", err
+	}
+
+	if sourcePackage == "" {
+		if len(sources) > 1 {
+			return "", fmt.Errorf("Bug #%d was filed against multiple source packages (%s), please specify -source_package", bug, strings.Join(sources, ", "))
+		}
+		log.Printf("Inferred source package %q from bug #%d", sources[0], bug)
+		return sources[0], nil
+	}
+
+	for _, source := range sources {
+		if source == sourcePackage {
+			return sourcePackage, nil
+		}
+	}
+	return "", fmt.Errorf("Bug #%d was filed against source package %s, but -source_package=%q was specified", bug, strings.Join(sources, ", "), sourcePackage)
+}
+
+// foundVersions returns the versions in which the specified bug was
+// found, without source package prefix (e.g. “2.31a-2” for
+// “wit/2.31a-2”), most recent (i.e. last reported) first.
+func foundVersions(client *debbugs.Client, bug int) ([]string, error) {
+	statuses, err := client.GetStatus(bug)
+	if err != nil {
+		return nil, err
+	}
+	status, ok := statuses[bug]
+	if !ok {
+		return nil, fmt.Errorf("Bug #%d not found", bug)
+	}
+	versions := make([]string, 0, len(status.FoundVersions))
+	for idx := len(status.FoundVersions) - 1; idx >= 0; idx-- {
+		version := status.FoundVersions[idx]
+		if slash := strings.LastIndex(version, "/"); slash > -1 {
+			version = version[slash+1:]
+		}
+		versions = append(versions, version)
+	}
+	return versions, nil
+}
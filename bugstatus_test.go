@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/Debian/mergebot/debbugs"
+	"github.com/Debian/mergebot/debbugs/debbugstest"
+)
+
+func TestResolveSourcePackage(t *testing.T) {
+	srv := debbugstest.NewServer()
+	defer srv.Close()
+	srv.Statuses[831331] = debbugs.Status{
+		Package: "wit",
+		Source:  "wit",
+	}
+	client := debbugs.NewClient(srv.URL)
+
+	source, err := resolveSourcePackage(client, 831331, "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got, want := source, "wit"; got != want {
+		t.Fatalf("Incorrect inferred source package: got %q, want %q", got, want)
+	}
+
+	if _, err := resolveSourcePackage(client, 831331, "wit"); err != nil {
+		t.Fatalf("Unexpected error for matching -source_package: %v", err)
+	}
+
+	if _, err := resolveSourcePackage(client, 831331, "min"); err == nil {
+		t.Fatalf("resolveSourcePackage unexpectedly accepted a -source_package which does not match the bug")
+	}
+}
+
+func TestResolveSourcePackageBinary(t *testing.T) {
+	srv := debbugstest.NewServer()
+	defer srv.Close()
+	srv.Statuses[1] = debbugs.Status{
+		Package: "libmin1",
+		Source:  "min",
+	}
+	srv.Statuses[2] = debbugs.Status{
+		Package: "src:min",
+	}
+	client := debbugs.NewClient(srv.URL)
+
+	for _, bug := range []int{1, 2} {
+		source, err := resolveSourcePackage(client, bug, "")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if got, want := source, "min"; got != want {
+			t.Fatalf("Incorrect inferred source package for bug #%d: got %q, want %q", bug, got, want)
+		}
+	}
+}
+
+func TestResolveSourcePackageMultiple(t *testing.T) {
+	srv := debbugstest.NewServer()
+	defer srv.Close()
+	srv.Statuses[1] = debbugs.Status{
+		Package: "min,src:other",
+		Source:  "min,other",
+	}
+	client := debbugs.NewClient(srv.URL)
+
+	if _, err := resolveSourcePackage(client, 1, ""); err == nil {
+		t.Fatalf("resolveSourcePackage unexpectedly inferred a source package for a bug filed against multiple packages")
+	}
+
+	source, err := resolveSourcePackage(client, 1, "other")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got, want := source, "other"; got != want {
+		t.Fatalf("Incorrect source package: got %q, want %q", got, want)
+	}
+}
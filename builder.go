@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/Debian/mergebot/changelog"
+)
+
+var (
+	builderName = flag.String("builder",
+		"sbuild",
+		"Builder to use for building the package. One of sbuild, pbuilder, cowbuilder (both via git-pbuilder), dpkg-buildpackage (on the host, build dependencies must be installed) and podman or docker (dpkg-buildpackage within a container).")
+
+	containerImage = flag.String("container_image",
+		"",
+		"Image for -builder=podman and -builder=docker. Defaults to debian:<suite>, e.g. debian:unstable.")
+)
+
+// pbuilderCacheDir is the directory containing the pbuilder base
+// tarballs and cowbuilder base directories, see git-pbuilder(1).
+var pbuilderCacheDir = "/var/cache/pbuilder"
+
+// builder builds the package for a suite.
+type builder interface {
+	// Check verifies that the package can be built for suite (e.g.
+	// that the required chroot exists), so that mergebot fails before
+	// cloning and merging.
+	Check(suite string) error
+
+	// Command returns the shell command (as passed to gbp buildpackage
+	// --git-builder) which builds the package for suite in the current
+	// directory, placing the results in the parent directory.
+	Command(suite string) (string, error)
+}
+
+// buildResult lists the files (absolute paths) produced by a build.
+type buildResult struct {
+	Changes   string
+	Buildinfo string
+	// Dsc is empty for binary-only builds.
+	Dsc  string
+	Debs []string
+}
+
+var builders = map[string]builder{
+	"sbuild":            sbuildBuilder{},
+	"pbuilder":          pbuilderBuilder{"pbuilder"},
+	"cowbuilder":        pbuilderBuilder{"cowbuilder"},
+	"dpkg-buildpackage": dpkgBuilder{},
+	"podman":            containerBuilder{"podman"},
+	"docker":            containerBuilder{"docker"},
+}
+
+// lookupBuilder returns the builder called name.
+func lookupBuilder(name string) (builder, error) {
+	b, err := lookupChoice("-builder", builders, name)
+	if err != nil {
+		return nil, err
+	}
+	return b.(builder), nil
+}
+
+// shellQuote quotes arg for use in a shell command line, if necessary.
+func shellQuote(arg string) string {
+	if !strings.ContainsAny(arg, " \t'\"$`\\") {
+		return arg
+	}
+	return "'" + strings.Replace(arg, "'", `'\''`, -1) + "'"
+}
+
+// shellCommand returns args as a shell command line.
+func shellCommand(args []string) string {
+	quoted := make([]string, len(args))
+	for idx, arg := range args {
+		quoted[idx] = shellQuote(arg)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// sbuildBuilder builds in an sbuild chroot, see sbuildChroot.
+type sbuildBuilder struct{}
+
+func (sbuildBuilder) Check(suite string) error {
+	chroot, err := sbuildChroot(suite)
+	if err != nil {
+		return err
+	}
+	return checkChroot(chroot)
+}
+
+func (sbuildBuilder) Command(suite string) (string, error) {
+	chroot, err := sbuildChroot(suite)
+	if err != nil {
+		return "", err
+	}
+	return shellCommand(append([]string{"sbuild"}, sbuildArgs(suite, chroot)...)), nil
+}
+
+// pbuilderBuilder builds using pbuilder or cowbuilder (Name), invoked
+// via git-pbuilder, which selects the base tarball (or base directory)
+// by distribution.
+type pbuilderBuilder struct {
+	Name string
+}
+
+// dist returns the value of the DIST environment variable for
+// git-pbuilder, which uses base.tgz (or base.cow) for unstable.
+func (b pbuilderBuilder) dist(suite string) string {
+	if base := baseSuite(suite); base != "unstable" {
+		return base
+	}
+	return ""
+}
+
+func (b pbuilderBuilder) basePath(suite string) string {
+	ext := ".tgz"
+	if b.Name == "cowbuilder" {
+		ext = ".cow"
+	}
+	if dist := b.dist(suite); dist != "" {
+		return filepath.Join(pbuilderCacheDir, "base-"+dist+ext)
+	}
+	return filepath.Join(pbuilderCacheDir, "base"+ext)
+}
+
+func (b pbuilderBuilder) Check(suite string) error {
+	if _, err := os.Stat(b.basePath(suite)); err != nil {
+		return fmt.Errorf("%s base for suite %q not found, create it using DIST=%s git-pbuilder create: %v", b.Name, suite, b.dist(suite), err)
+	}
+	return nil
+}
+
+func (b pbuilderBuilder) Command(suite string) (string, error) {
+	command := "BUILDER=" + b.Name
+	if dist := b.dist(suite); dist != "" {
+		command += " DIST=" + shellQuote(dist)
+	}
+	return command + " git-pbuilder", nil
+}
+
+// dpkgBuilder builds on the host using dpkg-buildpackage, regardless of
+// the suite.
+type dpkgBuilder struct{}
+
+func (dpkgBuilder) Check(suite string) error {
+	if _, err := exec.LookPath("dpkg-buildpackage"); err != nil {
+		return err
+	}
+	log.Printf("Building on the host, whose build dependencies might not match suite %q", suite)
+	return nil
+}
+
+func (dpkgBuilder) Command(suite string) (string, error) {
+	return "dpkg-buildpackage -us -uc", nil
+}
+
+// containerBuilder builds using dpkg-buildpackage within a (temporary)
+// container, started using Tool (podman or docker).
+type containerBuilder struct {
+	Tool string
+}
+
+func (b containerBuilder) image(suite string) string {
+	if *containerImage != "" {
+		return *containerImage
+	}
+	return "debian:" + baseSuite(suite)
+}
+
+func (b containerBuilder) Check(suite string) error {
+	_, err := exec.LookPath(b.Tool)
+	return err
+}
+
+func (b containerBuilder) Command(suite string) (string, error) {
+	// Hand the results back to the invoking user, as the build runs
+	// as root within the container.
+	script := fmt.Sprintf("apt-get update && apt-get -y build-dep ./ && dpkg-buildpackage -us -uc; status=$?; chown -R %d:%d /build; exit $status", os.Getuid(), os.Getgid())
+	return b.Tool + ` run --rm --volume "$(dirname "$PWD")":/build --workdir "/build/$(basename "$PWD")" ` +
+		shellCommand([]string{b.image(suite), "sh", "-c", script}), nil
+}
+
+// changesFiles returns the names of the files listed in the Files
+// field of the .changes file in path.
+func changesFiles(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var files []string
+	inFiles := false
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, " ") {
+			inFiles = line == "Files:"
+			continue
+		}
+		if fields := strings.Fields(line); inFiles && len(fields) == 5 {
+			files = append(files, fields[4])
+		}
+	}
+	return files, scanner.Err()
+}
+
+// findBuildResult returns the files produced by building version of
+// source into exportDir, based on the .changes file.
+func findBuildResult(exportDir, source, version string) (buildResult, error) {
+	if idx := strings.Index(version, ":"); idx > -1 {
+		// File names do not contain the epoch.
+		version = version[idx+1:]
+	}
+	matches, err := filepath.Glob(filepath.Join(exportDir, source+"_"+version+"_*.changes"))
+	if err != nil {
+		return buildResult{}, err
+	}
+	var result buildResult
+	for _, match := range matches {
+		// Prefer the .changes file of the binary upload over the
+		// _source.changes file, which some builders create in addition.
+		if result.Changes == "" || !strings.HasSuffix(match, "_source.changes") {
+			result.Changes = match
+		}
+	}
+	if result.Changes == "" {
+		return buildResult{}, fmt.Errorf("No .changes file for %s %s found in %q", source, version, exportDir)
+	}
+	files, err := changesFiles(result.Changes)
+	if err != nil {
+		return buildResult{}, err
+	}
+	for _, file := range files {
+		switch filepath.Ext(file) {
+		case ".buildinfo":
+			result.Buildinfo = filepath.Join(exportDir, file)
+		case ".dsc":
+			result.Dsc = filepath.Join(exportDir, file)
+		case ".deb", ".udeb":
+			result.Debs = append(result.Debs, filepath.Join(exportDir, file))
+		}
+	}
+	return result, nil
+}
+
+// buildPackage builds the package in checkoutDir for suite using b and
+// returns the produced files.
+func buildPackage(checkoutDir string, b builder, suite string) (buildResult, error) {
+	command, err := b.Command(suite)
+	if err != nil {
+		return buildResult{}, err
+	}
+	exportDir := filepath.Join(filepath.Dir(checkoutDir), "export")
+	err = newCommand("gbp", "buildpackage",
+		// Tag debian/%(version)s after building successfully.
+		"--git-tag",
+		// Build in a separate directory to avoid modifying the git checkout.
+		"--git-export-dir="+exportDir,
+		"--git-builder="+command).Run()
+	if err != nil {
+		return buildResult{}, err
+	}
+
+	c, err := changelog.ReadFile(filepath.Join(checkoutDir, "debian", "changelog"))
+	if err != nil {
+		return buildResult{}, err
+	}
+	if len(c.Entries) == 0 {
+		return buildResult{}, fmt.Errorf("debian/changelog does not contain any entries")
+	}
+	return findBuildResult(exportDir, c.Entries[0].Source, c.Entries[0].Version)
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBuilderCommands(t *testing.T) {
+	for _, tt := range []struct {
+		builder string
+		suite   string
+		want    string
+	}{
+		{"pbuilder", "unstable", "BUILDER=pbuilder git-pbuilder"},
+		{"cowbuilder", "bookworm-backports", "BUILDER=cowbuilder DIST=bookworm git-pbuilder"},
+		{"dpkg-buildpackage", "unstable", "dpkg-buildpackage -us -uc"},
+	} {
+		b, err := lookupBuilder(tt.builder)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := b.Command(tt.suite)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("%s command for %q: got %q, want %q", tt.builder, tt.suite, got, tt.want)
+		}
+	}
+
+	if _, err := lookupBuilder("debuild"); err == nil {
+		t.Fatalf("lookupBuilder unexpectedly succeeded for an unknown builder")
+	}
+}
+
+func TestContainerBuilderCommand(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "test-container-builder-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// Divert podman with a shell script which prints its arguments, so
+	// that the quoting of the command can be verified.
+	if err := ioutil.WriteFile(filepath.Join(tempDir, "podman"), []byte("#!/bin/sh\nprintf '%s\\n' \"$@\"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	buildDir := filepath.Join(tempDir, "export", "min-1.1")
+	if err := os.MkdirAll(buildDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	command, err := containerBuilder{"podman"}.Command("bookworm-backports")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Dir = buildDir
+	cmd.Env = append(os.Environ(), "PATH="+tempDir+":"+os.Getenv("PATH"), "PWD="+buildDir)
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	args := strings.Split(strings.TrimSpace(string(output)), "\n")
+	want := []string{
+		"run",
+		"--rm",
+		"--volume",
+		filepath.Join(tempDir, "export") + ":/build",
+		"--workdir",
+		"/build/min-1.1",
+		"debian:bookworm",
+		"sh",
+		"-c",
+	}
+	if len(args) != len(want)+1 || !reflect.DeepEqual(args[:len(want)], want) {
+		t.Fatalf("Unexpected podman arguments: got %q, want %q followed by the build script", args, want)
+	}
+	if script := args[len(want)]; !strings.Contains(script, "dpkg-buildpackage -us -uc") {
+		t.Fatalf("Build script %q does not invoke dpkg-buildpackage", script)
+	}
+}
+
+func TestPbuilderCheck(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "test-pbuilder-check-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	oldCacheDir := pbuilderCacheDir
+	defer func() { pbuilderCacheDir = oldCacheDir }()
+	pbuilderCacheDir = tempDir
+
+	if err := os.Mkdir(filepath.Join(tempDir, "base-bookworm.cow"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := (pbuilderBuilder{"cowbuilder"}).Check("bookworm-backports"); err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	if err := (pbuilderBuilder{"cowbuilder"}).Check("unstable"); err == nil {
+		t.Fatalf("Check unexpectedly succeeded without base.cow")
+	}
+	if err := (pbuilderBuilder{"pbuilder"}).Check("bookworm"); err == nil {
+		t.Fatalf("Check unexpectedly succeeded without base-bookworm.tgz")
+	}
+}
+
+func TestFindBuildResult(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "test-find-build-result-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	const changes = `Format: 1.8
+Source: min
+Binary: min
+Architecture: source amd64
+Version: 1:1.1
+Checksums-Sha256:
+ 0a1b 512 min_1.1.dsc
+Files:
+ 9f3e 512 devel extra min_1.1.dsc
+ 7c2d 1024 devel extra min_1.1.tar.xz
+ 41aa 2048 devel extra min_1.1_amd64.deb
+ 5b6f 4096 devel extra min_1.1_amd64.buildinfo
+`
+	for name, contents := range map[string]string{
+		"min_1.1_source.changes": "Files:\n",
+		"min_1.1_amd64.changes":  changes,
+	} {
+		if err := ioutil.WriteFile(filepath.Join(tempDir, name), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := findBuildResult(tempDir, "min", "1:1.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := buildResult{
+		Changes:   filepath.Join(tempDir, "min_1.1_amd64.changes"),
+		Buildinfo: filepath.Join(tempDir, "min_1.1_amd64.buildinfo"),
+		Dsc:       filepath.Join(tempDir, "min_1.1.dsc"),
+		Debs:      []string{filepath.Join(tempDir, "min_1.1_amd64.deb")},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Unexpected build result: got %+v, want %+v", got, want)
+	}
+
+	if _, err := findBuildResult(tempDir, "min", "1.2"); err == nil {
+		t.Fatalf("findBuildResult unexpectedly succeeded for a version which was not built")
+	}
+}
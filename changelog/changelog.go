@@ -0,0 +1,404 @@
+// changelog parses and writes debian/changelog files, see
+// https://www.debian.org/doc/debian-policy/ch-source.html#debian-changelog-debian-changelog
+//
+// Entries which are not modified after parsing are written back
+// byte-for-byte, so that reformatting a changelog never touches the
+// history of a package.
+package changelog
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DateLayout is the time layout of the date in an entry’s trailer line,
+// as produced by date -R.
+const DateLayout = "Mon, 02 Jan 2006 15:04:05 -0700"
+
+// Unreleased is the distribution of entries which were not uploaded
+// yet, see dch(1).
+const Unreleased = "UNRELEASED"
+
+// Changelog is a parsed debian/changelog file.
+type Changelog struct {
+	// Entries are ordered like in the file, i.e. most recent first.
+	Entries []*Entry
+
+	// epilogue is the text following the last entry, e.g. an Emacs
+	// “Local variables” block.
+	epilogue string
+}
+
+// Entry is one entry of a changelog, i.e. the changes of one version.
+type Entry struct {
+	Source  string
+	Version string
+
+	// Distributions are the distributions into which the version is
+	// uploaded, e.g. “unstable” or UNRELEASED.
+	Distributions []string
+
+	Urgency string
+
+	// Keywords are the key=value pairs following the urgency, e.g.
+	// “binary-only=yes”.
+	Keywords []string
+
+	Sections []*Section
+
+	// Maintainer is the person who released the entry, in “Name
+	// <email>” form.
+	Maintainer string
+
+	// Date is the date of the release, see DateLayout.
+	Date string
+
+	// separator is the text preceding the header line of the entry,
+	// i.e. an empty line for all but the first entry.
+	separator string
+
+	// raw is the entry as parsed, and canonical is the result of
+	// rendering it right after parsing. As long as rendering still
+	// yields canonical, the entry was not modified and raw is written.
+	raw, canonical string
+}
+
+// Section is a group of changes. Entries containing changes by more
+// than one person group them into sections named after the person, e.g.
+// “[ Chris Lamb ]”. The changes preceding the first named section are
+// in a section with an empty Name.
+type Section struct {
+	Name    string
+	Bullets []Bullet
+}
+
+// Bullet is one change, e.g. “* Fix FTBFS with GCC 6 (Closes: #811699)”.
+type Bullet struct {
+	// Lines are the lines of the change, including their indentation,
+	// but without trailing newline, e.g. “  * Fix FTBFS”, “    with GCC 6”.
+	Lines []string
+}
+
+// Text returns the text of the change, with lines joined by spaces and
+// without the bullet.
+func (b Bullet) Text() string {
+	var words []string
+	for _, line := range b.Lines {
+		line = strings.TrimSpace(line)
+		words = append(words, strings.TrimSpace(strings.TrimPrefix(line, "*")))
+	}
+	return strings.Join(words, " ")
+}
+
+// Closes returns the bugs which the change closes.
+func (b Bullet) Closes() []int {
+	return ClosedBugs(b.Text())
+}
+
+// Append appends text to the last line of the change or, in case that
+// line would exceed 80 characters, as a new line.
+func (b *Bullet) Append(text string) {
+	last := &b.Lines[len(b.Lines)-1]
+	if len([]rune(*last))+1+len([]rune(text)) > 80 {
+		b.Lines = append(b.Lines, "    "+text)
+		return
+	}
+	*last += " " + text
+}
+
+var (
+	// closesRe matches references to bugs which are closed by a change,
+	// as recognized by dpkg, e.g. “Closes: #815710, #831331”.
+	closesRe = regexp.MustCompile(`(?i)closes:\s*(?:bug)?\#?\s?\d+(?:,\s*(?:bug)?\#?\s?\d+)*`)
+
+	bugNumberRe = regexp.MustCompile(`\d+`)
+)
+
+// ClosedBugs returns the bugs which text (e.g. a change) closes.
+func ClosedBugs(text string) []int {
+	var bugs []int
+	for _, closes := range closesRe.FindAllString(text, -1) {
+		for _, number := range bugNumberRe.FindAllString(closes, -1) {
+			bug, err := strconv.Atoi(number)
+			if err != nil {
+				continue
+			}
+			bugs = append(bugs, bug)
+		}
+	}
+	return bugs
+}
+
+var (
+	// headerRe matches an entry’s header line, e.g. “wit (2.31a-2)
+	// unstable; urgency=medium”.
+	headerRe = regexp.MustCompile(`^(\S+) \(([^ ()]+)\)((?:\s+[-+.0-9a-zA-Z/]+)+)\s*;(.*)$`)
+
+	// trailerRe matches an entry’s trailer line, e.g. “ -- Michael
+	// Stapelberg <stapelberg@debian.org>  Sun, 17 Jul 2016 00:36:19 +0200”.
+	trailerRe = regexp.MustCompile(`^ -- (.*<.*>)  (.*)$`)
+
+	// sectionRe matches a section name line, e.g. “  [ Chris Lamb ]”.
+	sectionRe = regexp.MustCompile(`^\s+\[ (.*) \]\s*$`)
+
+	// bulletRe matches the first line of a change.
+	bulletRe = regexp.MustCompile(`^\s+[*+-] `)
+)
+
+// Parse parses the changelog read from r.
+func Parse(r io.Reader) (*Changelog, error) {
+	var (
+		c         Changelog
+		current   *Entry
+		raw       bytes.Buffer
+		separator bytes.Buffer
+		lineNum   int
+	)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		lineNum++
+		if current == nil {
+			matches := headerRe.FindStringSubmatch(line)
+			if matches == nil {
+				// Text between entries (or preceding the first one)
+				// belongs to the next entry or to the epilogue.
+				separator.WriteString(line + "\n")
+				continue
+			}
+			current = &Entry{
+				Source:        matches[1],
+				Version:       matches[2],
+				Distributions: strings.Fields(matches[3]),
+				separator:     separator.String(),
+			}
+			for _, keyword := range strings.Split(matches[4], ",") {
+				keyword = strings.TrimSpace(keyword)
+				if strings.HasPrefix(strings.ToLower(keyword), "urgency=") {
+					current.Urgency = keyword[len("urgency="):]
+				} else if keyword != "" {
+					current.Keywords = append(current.Keywords, keyword)
+				}
+			}
+			separator.Reset()
+			raw.Reset()
+			raw.WriteString(line + "\n")
+			continue
+		}
+
+		raw.WriteString(line + "\n")
+		if matches := trailerRe.FindStringSubmatch(line); matches != nil {
+			current.Maintainer = matches[1]
+			current.Date = matches[2]
+			current.raw = raw.String()
+			current.canonical = current.render()
+			c.Entries = append(c.Entries, current)
+			current = nil
+			continue
+		}
+		if matches := sectionRe.FindStringSubmatch(line); matches != nil {
+			current.Sections = append(current.Sections, &Section{Name: matches[1]})
+			continue
+		}
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if len(current.Sections) == 0 {
+			current.Sections = append(current.Sections, &Section{})
+		}
+		section := current.Sections[len(current.Sections)-1]
+		if bulletRe.MatchString(line) || len(section.Bullets) == 0 {
+			section.Bullets = append(section.Bullets, Bullet{})
+		}
+		bullet := &section.Bullets[len(section.Bullets)-1]
+		bullet.Lines = append(bullet.Lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if current != nil {
+		return nil, fmt.Errorf("line %d: entry for version %q lacks a trailer line", lineNum, current.Version)
+	}
+	c.epilogue = separator.String()
+	return &c, nil
+}
+
+// ReadFile parses the changelog stored in path.
+func ReadFile(path string) (*Changelog, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	c, err := Parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("%q: %v", path, err)
+	}
+	return c, nil
+}
+
+// WriteFile writes the changelog to path.
+func (c *Changelog) WriteFile(path string) error {
+	return ioutil.WriteFile(path, []byte(c.String()), 0644)
+}
+
+// String returns the changelog in debian/changelog format.
+func (c *Changelog) String() string {
+	var buf bytes.Buffer
+	for idx, e := range c.Entries {
+		separator := e.separator
+		if idx > 0 && separator == "" {
+			separator = "\n"
+		}
+		buf.WriteString(separator)
+		buf.WriteString(e.String())
+	}
+	buf.WriteString(c.epilogue)
+	return buf.String()
+}
+
+// AddEntry adds e as the most recent entry.
+func (c *Changelog) AddEntry(e *Entry) {
+	if len(c.Entries) > 0 {
+		// Text preceding the first entry remains at the top.
+		e.separator, c.Entries[0].separator = c.Entries[0].separator, "\n"
+	}
+	c.Entries = append([]*Entry{e}, c.Entries...)
+}
+
+// String returns the entry in debian/changelog format, from its header
+// line up to and including its trailer line.
+func (e *Entry) String() string {
+	rendered := e.render()
+	if e.raw != "" && rendered == e.canonical {
+		return e.raw
+	}
+	return rendered
+}
+
+func (e *Entry) render() string {
+	var buf bytes.Buffer
+	keywords := e.Keywords
+	if e.Urgency != "" {
+		keywords = append([]string{"urgency=" + e.Urgency}, keywords...)
+	}
+	fmt.Fprintf(&buf, "%s (%s) %s; %s\n\n", e.Source, e.Version, strings.Join(e.Distributions, " "), strings.Join(keywords, ", "))
+	first := true
+	for _, section := range e.Sections {
+		// gbp dch and dch leave behind sections without changes, which
+		// are omitted.
+		if len(section.Bullets) == 0 {
+			continue
+		}
+		if section.Name != "" {
+			if !first {
+				buf.WriteString("\n")
+			}
+			fmt.Fprintf(&buf, "  [ %s ]\n", section.Name)
+		}
+		for _, bullet := range section.Bullets {
+			for _, line := range bullet.Lines {
+				buf.WriteString(line + "\n")
+			}
+		}
+		first = false
+	}
+	fmt.Fprintf(&buf, "\n -- %s  %s\n", e.Maintainer, e.Date)
+	return buf.String()
+}
+
+// Released returns whether the entry was uploaded (or at least
+// finalized for an upload), i.e. whether its distribution is not
+// UNRELEASED.
+func (e *Entry) Released() bool {
+	return len(e.Distributions) != 1 || e.Distributions[0] != Unreleased
+}
+
+// maintainerName returns the name part of e.Maintainer.
+func (e *Entry) maintainerName() string {
+	if idx := strings.Index(e.Maintainer, " <"); idx > -1 {
+		return e.Maintainer[:idx]
+	}
+	return e.Maintainer
+}
+
+// AddBullet adds a change with the specified text (wrapped at 80
+// characters) to the section named name, which is created if
+// necessary. An empty name refers to the changes of the entry’s
+// Maintainer. Like dch(1), AddBullet names the unnamed section after
+// Maintainer as soon as changes by somebody else are added.
+func (e *Entry) AddBullet(name, text string) {
+	if name == e.maintainerName() {
+		name = ""
+	}
+	if name != "" && len(e.Sections) > 0 && e.Sections[0].Name == "" && len(e.Sections[0].Bullets) > 0 {
+		e.Sections[0].Name = e.maintainerName()
+	}
+	var section *Section
+	for _, s := range e.Sections {
+		if s.Name == name || (name == "" && s.Name == e.maintainerName()) {
+			section = s
+			break
+		}
+	}
+	if section == nil {
+		section = &Section{Name: name}
+		if name == "" {
+			// The maintainer’s changes go first.
+			e.Sections = append([]*Section{section}, e.Sections...)
+		} else {
+			e.Sections = append(e.Sections, section)
+		}
+	}
+	section.Bullets = append(section.Bullets, Bullet{Lines: wrap("  * ", "    ", text, 80)})
+}
+
+// Finalize marks an UNRELEASED entry as released by maintainer into
+// distribution at date, like dch --release. In case maintainer differs
+// from the entry’s previous Maintainer, the unnamed section is named
+// after the latter, so that its changes remain attributed correctly.
+func (e *Entry) Finalize(maintainer, distribution string, date time.Time) error {
+	if e.Released() {
+		return fmt.Errorf("version %q was already released into %q", e.Version, strings.Join(e.Distributions, " "))
+	}
+	previous := e.maintainerName()
+	e.Maintainer = maintainer
+	if name := e.maintainerName(); name != previous && previous != "" {
+		for _, s := range e.Sections {
+			if s.Name == "" && len(s.Bullets) > 0 {
+				s.Name = previous
+			}
+		}
+	}
+	e.Distributions = []string{distribution}
+	e.Date = date.Format(DateLayout)
+	return nil
+}
+
+// wrap splits text into lines of at most width characters (unless a
+// single word is longer), the first one prefixed with first, all
+// others with rest.
+func wrap(first, rest, text string, width int) []string {
+	var lines []string
+	line := first
+	for _, word := range strings.Fields(text) {
+		if line != first && line != rest && len([]rune(line))+1+len([]rune(word)) > width {
+			lines = append(lines, line)
+			line = rest
+		}
+		if line != first && line != rest {
+			line += " "
+		}
+		line += word
+	}
+	return append(lines, line)
+}
@@ -0,0 +1,190 @@
+package changelog_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Debian/mergebot/changelog"
+)
+
+// witChangelog contains unusual (but valid) formatting, which must
+// survive a round trip.
+const witChangelog = `wit (2.31a-3) UNRELEASED; urgency=medium
+
+  * Don’t link wfuse against libdl, which
+    is not required anymore.
+
+ -- Michael Stapelberg <stapelberg@debian.org>  Sat, 16 Jul 2016 20:39:13 +0200
+
+wit (2.31a-2) unstable; urgency=low, binary-only=yes
+
+  [ Tobias Gruetzmacher ]
+  * Add zlib support (Closes: #815710)
+
+
+  [ Michael Stapelberg ]
+  * Bump Standards-Version
+
+ --  Michael Stapelberg <stapelberg@debian.org>  Tue, 23 Feb 2016 23:40:46 +0100
+
+
+wit (2.31a-1) experimental unstable; urgency=low
+
+  * Initial release
+
+ -- Michael Stapelberg <stapelberg@debian.org>  Mon, 22 Feb 2016 21:17:29 +0100
+
+Local variables:
+mode: debian-changelog
+End:
+`
+
+func parse(t *testing.T, contents string) *changelog.Changelog {
+	c, err := changelog.Parse(strings.NewReader(contents))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestParse(t *testing.T) {
+	c := parse(t, witChangelog)
+	if got, want := len(c.Entries), 3; got != want {
+		t.Fatalf("Unexpected number of entries: got %d, want %d", got, want)
+	}
+
+	e := c.Entries[1]
+	if got, want := e.Source, "wit"; got != want {
+		t.Fatalf("Unexpected source: got %q, want %q", got, want)
+	}
+	if got, want := e.Version, "2.31a-2"; got != want {
+		t.Fatalf("Unexpected version: got %q, want %q", got, want)
+	}
+	if got, want := e.Urgency, "low"; got != want {
+		t.Fatalf("Unexpected urgency: got %q, want %q", got, want)
+	}
+	if got, want := e.Keywords, []string{"binary-only=yes"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Unexpected keywords: got %q, want %q", got, want)
+	}
+	if got, want := e.Maintainer, " Michael Stapelberg <stapelberg@debian.org>"; got != want {
+		t.Fatalf("Unexpected maintainer: got %q, want %q", got, want)
+	}
+	if got, want := e.Date, "Tue, 23 Feb 2016 23:40:46 +0100"; got != want {
+		t.Fatalf("Unexpected date: got %q, want %q", got, want)
+	}
+	if got, want := len(e.Sections), 2; got != want {
+		t.Fatalf("Unexpected number of sections: got %d, want %d", got, want)
+	}
+	if got, want := e.Sections[0].Name, "Tobias Gruetzmacher"; got != want {
+		t.Fatalf("Unexpected section name: got %q, want %q", got, want)
+	}
+	if got, want := e.Sections[0].Bullets[0].Text(), "Add zlib support (Closes: #815710)"; got != want {
+		t.Fatalf("Unexpected bullet: got %q, want %q", got, want)
+	}
+
+	if got, want := c.Entries[0].Sections[0].Bullets[0].Text(), "Don’t link wfuse against libdl, which is not required anymore."; got != want {
+		t.Fatalf("Unexpected bullet: got %q, want %q", got, want)
+	}
+	if c.Entries[0].Released() {
+		t.Fatalf("UNRELEASED entry unexpectedly considered released")
+	}
+	if got, want := c.Entries[2].Distributions, []string{"experimental", "unstable"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Unexpected distributions: got %q, want %q", got, want)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	if got, want := parse(t, witChangelog).String(), witChangelog; got != want {
+		t.Fatalf("Changelog modified by round trip: got %q, want %q", got, want)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	_, err := changelog.Parse(strings.NewReader("wit (2.31a-3) unstable; urgency=medium\n\n  * Fix\n"))
+	if err == nil {
+		t.Fatalf("Parse unexpectedly succeeded for an entry without trailer line")
+	}
+}
+
+func TestModify(t *testing.T) {
+	c := parse(t, witChangelog)
+
+	// The maintainer’s own change is moved into a named section as
+	// soon as changes by somebody else are added.
+	e := c.Entries[0]
+	e.AddBullet("Chris Lamb", "Fix for “wit: please make the build reproducible” (Closes: #831331)")
+	e.AddBullet("Michael Stapelberg", "Bump debhelper compat level to 10 and drop the explicit dh-autoreconf build dependency, which debhelper 10 enables by default")
+	date := time.Date(2016, 7, 17, 10, 0, 0, 0, time.FixedZone("", 2*60*60))
+	if err := e.Finalize("Michael Stapelberg <stapelberg@debian.org>", "unstable", date); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Entries[1].Finalize("Michael Stapelberg <stapelberg@debian.org>", "unstable", date); err == nil {
+		t.Fatalf("Finalize unexpectedly succeeded for a released entry")
+	}
+
+	c.AddEntry(&changelog.Entry{
+		Source:        "wit",
+		Version:       "2.31a-4",
+		Distributions: []string{changelog.Unreleased},
+		Urgency:       "medium",
+		Maintainer:    "Chris Lamb <lamby@debian.org>",
+		Date:          date.Format(changelog.DateLayout),
+	})
+	c.Entries[0].AddBullet("", "Non-maintainer upload.")
+
+	want := `wit (2.31a-4) UNRELEASED; urgency=medium
+
+  * Non-maintainer upload.
+
+ -- Chris Lamb <lamby@debian.org>  Sun, 17 Jul 2016 10:00:00 +0200
+
+wit (2.31a-3) unstable; urgency=medium
+
+  [ Michael Stapelberg ]
+  * Don’t link wfuse against libdl, which
+    is not required anymore.
+  * Bump debhelper compat level to 10 and drop the explicit dh-autoreconf build
+    dependency, which debhelper 10 enables by default
+
+  [ Chris Lamb ]
+  * Fix for “wit: please make the build reproducible” (Closes: #831331)
+
+ -- Michael Stapelberg <stapelberg@debian.org>  Sun, 17 Jul 2016 10:00:00 +0200
+` + witChangelog[strings.Index(witChangelog, "\nwit (2.31a-2)"):]
+	if got := c.String(); got != want {
+		t.Fatalf("Unexpected changelog: got %q, want %q", got, want)
+	}
+}
+
+func TestClosedBugs(t *testing.T) {
+	for _, tt := range []struct {
+		text string
+		want []int
+	}{
+		{"Add zlib support (Closes: #815710)", []int{815710}},
+		{"Fix FTBFS. closes: Bug#1, #2,#3", []int{1, 2, 3}},
+		{"Fix FTBFS (see #815710)", nil},
+	} {
+		if got := changelog.ClosedBugs(tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ClosedBugs(%q): got %v, want %v", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestAppend(t *testing.T) {
+	b := changelog.Bullet{Lines: []string{"  * Fix FTBFS"}}
+	b.Append("(Closes: #1)")
+	b.Append("and make the build reproducible by no longer embedding the build date")
+	want := []string{
+		"  * Fix FTBFS (Closes: #1)",
+		"    and make the build reproducible by no longer embedding the build date",
+	}
+	if !reflect.DeepEqual(b.Lines, want) {
+		t.Fatalf("Unexpected lines: got %q, want %q", b.Lines, want)
+	}
+	if got, want := b.Closes(), []int{1}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Unexpected closed bugs: got %v, want %v", got, want)
+	}
+}
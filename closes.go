@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/Debian/mergebot/changelog"
+)
+
+// addedChangelogLines returns the lines (without “+” prefix and
+// trailing newline) which the diff contained in data adds to
+// debian/changelog, or nil if it does not modify debian/changelog.
+func addedChangelogLines(data []byte) []string {
+	_, files := parseDiff(data)
+	var added []string
+	for _, f := range files {
+		if f.Name() != "debian/changelog" {
+			continue
+		}
+		for _, h := range f.Hunks {
+			for _, line := range h.Lines {
+				if strings.HasPrefix(line, "+") {
+					added = append(added, strings.TrimRight(line[1:], "\r\n"))
+				}
+			}
+		}
+	}
+	return added
+}
+
+// closesBug returns whether any of lines closes bug.
+func closesBug(lines []string, bug int) bool {
+	for _, closed := range changelog.ClosedBugs(strings.Join(lines, "\n")) {
+		if closed == bug {
+			return true
+		}
+	}
+	return false
+}
+
+// findBullet returns the first change in c whose first line is one of
+// lines, or nil if there is none.
+func findBullet(c *changelog.Changelog, lines []string) *changelog.Bullet {
+	isLine := make(map[string]bool)
+	for _, line := range lines {
+		isLine[line] = true
+	}
+	for _, e := range c.Entries {
+		for _, section := range e.Sections {
+			for idx, bullet := range section.Bullets {
+				if isLine[bullet.Lines[0]] {
+					return &section.Bullets[idx]
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// ensureCloses makes sure that the changes which p (just committed)
+// adds to debian/changelog close bug: in case they do not, a Closes
+// reference is appended to the first change added by p, and the commit
+// is amended. Patches which do not modify debian/changelog are listed
+// in the changelog by releaseChangelog instead.
+func ensureCloses(checkoutDir string, p patch, bug string) error {
+	added := addedChangelogLines(p.Data)
+	if len(added) == 0 || bug == "" {
+		return nil
+	}
+	bugNum, err := strconv.Atoi(bug)
+	if err != nil {
+		return err
+	}
+	if closesBug(added, bugNum) {
+		return nil
+	}
+
+	path := filepath.Join(checkoutDir, "debian", "changelog")
+	c, err := changelog.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	contributed := findBullet(c, added)
+	if contributed == nil {
+		log.Printf("The patch modifies %q without closing bug #%d, but none of its changes could be located", path, bugNum)
+		return nil
+	}
+
+	contributed.Append(fmt.Sprintf("(Closes: #%d)", bugNum))
+	if err := c.WriteFile(path); err != nil {
+		return err
+	}
+	log.Printf("Added missing “Closes: #%d” to the patch’s change in %q", bugNum, path)
+	return newCommand("git", "commit", "--amend", "--no-edit", "--", path).Run()
+}
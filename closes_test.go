@@ -0,0 +1,90 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAddedChangelogLines(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/changelog/closes.patch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"min (1.1) UNRELEASED; urgency=medium",
+		"",
+		"  * Add Homepage field. (Closes: #1)",
+		"",
+		" -- Chris Lamb <lamby@debian.org>  Mon, 18 Jul 2016 10:00:00 +0200",
+		"",
+	}
+	if got := addedChangelogLines(data); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Unexpected added lines: got %q, want %q", got, want)
+	}
+
+	data, err = ioutil.ReadFile("testdata/series/0002-Add-Homepage-field.patch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := addedChangelogLines(data); got != nil {
+		t.Fatalf("Unexpected added lines for a patch which does not modify debian/changelog: got %q", got)
+	}
+}
+
+func TestEnsureCloses(t *testing.T) {
+	for _, tt := range []struct {
+		fixture string
+		bug     string
+		want    string
+	}{
+		// The contributor’s change already closes the bug.
+		{"closes.patch", "1", "  * Add Homepage field. (Closes: #1)\n"},
+		// The Closes reference is missing and hence added.
+		{"no-closes.patch", "1", "  * Add Homepage field. (Closes: #1)\n"},
+		// Without a bug, there is nothing to close.
+		{"no-closes.patch", "", "  * Add Homepage field.\n"},
+	} {
+		tempDir, err := ioutil.TempDir("", "test-ensure-closes-")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(tempDir)
+
+		repo := setupQuiltRepo(t, tempDir)
+		restore := useRepo(repo)
+		data, err := ioutil.ReadFile(filepath.Join("testdata", "changelog", tt.fixture))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(tempDir, patchFileName), data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		p := patch{Data: data, Filename: tt.fixture}
+		if err := mergePatch(repo, p, "", ""); err != nil {
+			t.Fatalf("%s: mergePatch: %v", tt.fixture, err)
+		}
+		if err := ensureCloses(repo, p, tt.bug); err != nil {
+			t.Fatalf("%s: ensureCloses: %v", tt.fixture, err)
+		}
+		restore()
+
+		contents, err := ioutil.ReadFile(filepath.Join(repo, "debian", "changelog"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(contents), tt.want) {
+			t.Fatalf("%s (bug %q): changelog does not contain %q:\n%s", tt.fixture, tt.bug, tt.want, contents)
+		}
+		// The fix is part of the contributor’s commit.
+		if got := gitIn(t, repo, "status", "--porcelain"); got != "" {
+			t.Fatalf("%s: uncommitted changes: %q", tt.fixture, got)
+		}
+		if got, want := gitIn(t, repo, "log", "--format=%an: %s", "-1"), "Chris Lamb: Add Homepage field"; got != want {
+			t.Fatalf("%s: unexpected commit: got %q, want %q", tt.fixture, got, want)
+		}
+	}
+}
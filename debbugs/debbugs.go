@@ -0,0 +1,168 @@
+// debbugs is a client for the SOAP interface of the Debian bug
+// tracking system, see https://wiki.debian.org/DebbugsSoapInterface
+package debbugs
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"sort"
+)
+
+const (
+	// DefaultURL is the SOAP endpoint of bugs.debian.org.
+	DefaultURL = "https://bugs.debian.org/cgi-bin/soap.cgi"
+
+	// Namespace is the XML namespace of Debbugs SOAP methods.
+	Namespace = "Debbugs/SOAP"
+)
+
+// Client calls Debbugs SOAP methods on the server at URL.
+type Client struct {
+	URL string
+
+	// HTTPClient is used to make requests. Defaults to
+	// http.DefaultClient.
+	HTTPClient *http.Client
+}
+
+// NewClient returns a Client for the SOAP endpoint at url, e.g.
+// DefaultURL.
+func NewClient(url string) *Client {
+	return &Client{URL: url}
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+// Status is the status of a bug, as returned by get_status.
+type Status struct {
+	BugNum int `xml:"bug_num"`
+
+	// Package is a comma-separated list of the (binary or source)
+	// packages against which the bug was filed. Source packages are
+	// prefixed with “src:”.
+	Package string `xml:"package"`
+
+	// Source is a comma-separated list of the source packages of
+	// Package, as determined by Debbugs.
+	Source string `xml:"source"`
+
+	Subject    string `xml:"subject"`
+	Originator string `xml:"originator"`
+	Owner      string `xml:"owner"`
+	Severity   string `xml:"severity"`
+
+	// Tags is a space-separated list of tags, e.g. “patch upstream”.
+	Tags string `xml:"tags"`
+
+	// Done is the address of whoever closed the bug, or empty if
+	// the bug is open.
+	Done      string `xml:"done"`
+	Forwarded string `xml:"forwarded"`
+	Pending   string `xml:"pending"`
+
+	// MergedWith is a space-separated list of bug numbers.
+	MergedWith string `xml:"mergedwith"`
+
+	Archived bool `xml:"archived"`
+
+	// Date is the time at which the bug was filed, in seconds since
+	// the UNIX epoch.
+	Date int64 `xml:"date"`
+
+	// LogModified is the time at which the bug log was last
+	// modified, in seconds since the UNIX epoch.
+	LogModified int64 `xml:"log_modified"`
+
+	FoundVersions []string `xml:"found_versions>item"`
+	FixedVersions []string `xml:"fixed_versions>item"`
+}
+
+// BugLogItem is a single message of a bug log, as returned by
+// get_bug_log.
+type BugLogItem struct {
+	MsgNum int    `xml:"msg_num"`
+	Header string `xml:"header"`
+	Body   string `xml:"body"`
+}
+
+// GetStatus returns the status of the specified bugs, keyed by bug
+// number. Bugs which do not exist are omitted.
+func (c *Client) GetStatus(bugs ...int) (map[int]Status, error) {
+	var r struct {
+		Items []struct {
+			Key   int    `xml:"key"`
+			Value Status `xml:"value"`
+		} `xml:"item"`
+	}
+	if err := c.call("get_status", &r, bugs); err != nil {
+		return nil, err
+	}
+	result := make(map[int]Status, len(r.Items))
+	for _, item := range r.Items {
+		result[item.Key] = item.Value
+	}
+	return result, nil
+}
+
+// GetBugLog returns all messages of the specified bug.
+func (c *Client) GetBugLog(bug int) ([]BugLogItem, error) {
+	var r struct {
+		Items []BugLogItem `xml:"item"`
+	}
+	if err := c.call("get_bug_log", &r, bug); err != nil {
+		return nil, err
+	}
+	return r.Items, nil
+}
+
+// GetBugs returns the numbers of all bugs matching query, which
+// consists of key/value pairs, e.g. "package", "wit", "tag",
+// "patch". Values for the same key are ORed, different keys are ANDed.
+func (c *Client) GetBugs(query ...string) ([]int, error) {
+	if len(query)%2 != 0 {
+		return nil, fmt.Errorf("query must consist of key/value pairs, got %d elements", len(query))
+	}
+	params := make([]interface{}, len(query))
+	for idx, q := range query {
+		params[idx] = q
+	}
+	var r struct {
+		Items []int `xml:"item"`
+	}
+	if err := c.call("get_bugs", &r, params...); err != nil {
+		return nil, err
+	}
+	sort.Ints(r.Items)
+	return r.Items, nil
+}
+
+// GetUsertag returns the bug numbers tagged with the specified
+// usertags by the user identified by email, keyed by usertag. If no
+// tags are specified, all usertags of the user are returned.
+func (c *Client) GetUsertag(email string, tags ...string) (map[string][]int, error) {
+	params := []interface{}{email}
+	for _, tag := range tags {
+		params = append(params, tag)
+	}
+	// Debbugs returns a map whose element names are the usertags.
+	var r struct {
+		Tags []struct {
+			XMLName xml.Name
+			Bugs    []int `xml:"item"`
+		} `xml:",any"`
+	}
+	if err := c.call("get_usertag", &r, params...); err != nil {
+		return nil, err
+	}
+	result := make(map[string][]int, len(r.Tags))
+	for _, tag := range r.Tags {
+		result[tag.XMLName.Local] = tag.Bugs
+	}
+	return result, nil
+}
@@ -0,0 +1,149 @@
+package debbugs_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Debian/mergebot/debbugs"
+	"github.com/Debian/mergebot/debbugs/debbugstest"
+)
+
+func TestGetStatusRecorded(t *testing.T) {
+	srv := debbugstest.NewServer()
+	defer srv.Close()
+	srv.Recordings["get_status"] = "testdata/get_status.soap"
+
+	statuses, err := debbugs.NewClient(srv.URL).GetStatus(831331)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	status, ok := statuses[831331]
+	if !ok {
+		t.Fatalf("Bug #831331 not found in get_status response: %+v", statuses)
+	}
+	if got, want := status.Package, "wit"; got != want {
+		t.Fatalf("Incorrect package: got %q, want %q", got, want)
+	}
+	if got, want := status.Subject, "wit: please make the build reproducible"; got != want {
+		t.Fatalf("Incorrect subject: got %q, want %q", got, want)
+	}
+	if got, want := status.FoundVersions, []string{"wit/2.31a-2"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Incorrect found versions: got %v, want %v", got, want)
+	}
+	if got, want := status.LogModified, int64(1468686123); got != want {
+		t.Fatalf("Incorrect log_modified: got %d, want %d", got, want)
+	}
+}
+
+func TestGetBugLogRecorded(t *testing.T) {
+	srv := debbugstest.NewServer()
+	defer srv.Close()
+	srv.Recordings["get_bug_log"] = "testdata/get_bug_log.soap"
+
+	items, err := debbugs.NewClient(srv.URL).GetBugLog(831331)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got, want := len(items), 1; got != want {
+		t.Fatalf("Unexpected number of messages: got %d, want %d", got, want)
+	}
+	if got, want := items[0].MsgNum, 5; got != want {
+		t.Fatalf("Incorrect msg_num: got %d, want %d", got, want)
+	}
+	if !strings.Contains(items[0].Header, "From: Chris Lamb <lamby@debian.org>") {
+		t.Fatalf("Header does not contain the (unescaped) From line: %q", items[0].Header)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	srv := debbugstest.NewServer()
+	defer srv.Close()
+	srv.Statuses[1] = debbugs.Status{
+		Package:       "min",
+		Source:        "min",
+		Subject:       "min: <please> fix “quoting” & escaping",
+		Tags:          "patch",
+		FoundVersions: []string{"min/1.0"},
+	}
+	srv.Statuses[2] = debbugs.Status{
+		Package: "libmin1",
+		Source:  "min",
+		Tags:    "patch",
+		Done:    "Michael Stapelberg <stapelberg@debian.org>",
+	}
+	srv.Statuses[3] = debbugs.Status{
+		Package: "other",
+		Source:  "other",
+		Tags:    "patch",
+	}
+	srv.BugLogs[1] = []debbugs.BugLogItem{
+		{MsgNum: 5, Header: "From: Chris Lamb <lamby@debian.org>", Body: "a < b && c > d"},
+	}
+	srv.Usertags["reproducible-builds@lists.alioth.debian.org"] = map[string][]int{
+		"timestamps": []int{1, 3},
+		"umask":      []int{2},
+	}
+	client := debbugs.NewClient(srv.URL)
+
+	statuses, err := client.GetStatus(1, 2, 4)
+	if err != nil {
+		t.Fatalf("GetStatus: %v", err)
+	}
+	if got, want := len(statuses), 2; got != want {
+		t.Fatalf("GetStatus: unexpected number of statuses: got %d, want %d", got, want)
+	}
+	if got, want := statuses[1].Subject, srv.Statuses[1].Subject; got != want {
+		t.Fatalf("GetStatus: incorrect subject: got %q, want %q", got, want)
+	}
+
+	items, err := client.GetBugLog(1)
+	if err != nil {
+		t.Fatalf("GetBugLog: %v", err)
+	}
+	if got, want := items, srv.BugLogs[1]; !reflect.DeepEqual(got, want) {
+		t.Fatalf("GetBugLog: got %+v, want %+v", got, want)
+	}
+
+	bugs, err := client.GetBugs("src", "min", "tag", "patch", "status", "open")
+	if err != nil {
+		t.Fatalf("GetBugs: %v", err)
+	}
+	if got, want := bugs, []int{1}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("GetBugs: got %v, want %v", got, want)
+	}
+
+	bugs, err = client.GetBugs("package", "<none>")
+	if err != nil {
+		t.Fatalf("GetBugs: %v", err)
+	}
+	if got, want := len(bugs), 0; got != want {
+		t.Fatalf("GetBugs: unexpected number of bugs: got %d, want %d", got, want)
+	}
+
+	usertags, err := client.GetUsertag("reproducible-builds@lists.alioth.debian.org", "timestamps")
+	if err != nil {
+		t.Fatalf("GetUsertag: %v", err)
+	}
+	if got, want := usertags, map[string][]int{"timestamps": []int{1, 3}}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("GetUsertag: got %v, want %v", got, want)
+	}
+
+	if got, want := srv.Calls(), []string{"get_status", "get_bug_log", "get_bugs", "get_bugs", "get_usertag"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Unexpected calls: got %v, want %v", got, want)
+	}
+}
+
+func TestFault(t *testing.T) {
+	srv := debbugstest.NewServer()
+	defer srv.Close()
+
+	_, err := debbugs.NewClient(srv.URL).GetBugs("bogus", "key")
+	fault, ok := err.(*debbugs.Fault)
+	if !ok {
+		t.Fatalf("Unexpected error: got %v (%T), want a *debbugs.Fault", err, err)
+	}
+	if got, want := fault.Code, "soap:Server"; got != want {
+		t.Fatalf("Incorrect fault code: got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,380 @@
+// debbugstest provides a fake Debbugs SOAP server for use in tests.
+package debbugstest
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Debian/mergebot/debbugs"
+)
+
+// Server is a fake Debbugs SOAP server. Responses are computed from
+// Statuses, BugLogs and Usertags, unless a recorded response was
+// configured for the method in Recordings.
+//
+// Computed responses are sent as multipart/related (SOAP with
+// attachments, like SOAP::Lite does), recorded responses are sent
+// verbatim as text/xml.
+type Server struct {
+	*httptest.Server
+
+	// Statuses are used for get_status and get_bugs.
+	Statuses map[int]debbugs.Status
+
+	// BugLogs are used for get_bug_log.
+	BugLogs map[int][]debbugs.BugLogItem
+
+	// Usertags maps email address to usertag to bug numbers and is
+	// used for get_usertag.
+	Usertags map[string]map[string][]int
+
+	// Recordings maps method name (e.g. get_bug_log) to the path of
+	// a file containing a recorded SOAP response.
+	Recordings map[string]string
+
+	mu    sync.Mutex
+	calls []string
+}
+
+// NewServer starts and returns a new Server. The caller should call
+// Close when finished, to shut it down.
+func NewServer() *Server {
+	s := &Server{
+		Statuses:   make(map[int]debbugs.Status),
+		BugLogs:    make(map[int][]debbugs.BugLogItem),
+		Usertags:   make(map[string]map[string][]int),
+		Recordings: make(map[string]string),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// Calls returns the names of the methods which were called so far, in
+// order.
+func (s *Server) Calls() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.calls...)
+}
+
+type param struct {
+	Value string   `xml:",chardata"`
+	Items []string `xml:"item"`
+}
+
+func (p param) values() []string {
+	if len(p.Items) > 0 {
+		return p.Items
+	}
+	return []string{p.Value}
+}
+
+func (p param) ints() ([]int, error) {
+	var result []int
+	for _, v := range p.values() {
+		i, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, i)
+	}
+	return result, nil
+}
+
+type request struct {
+	Body struct {
+		Call struct {
+			XMLName xml.Name
+			Params  []param `xml:",any"`
+		} `xml:",any"`
+	} `xml:"http://schemas.xmlsoap.org/soap/envelope/ Body"`
+}
+
+const responseHeader = `<?xml version="1.0" encoding="UTF-8"?><soap:Envelope soap:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/" xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/" xmlns:soapenc="http://schemas.xmlsoap.org/soap/encoding/" xmlns:xsd="http://www.w3.org/1999/XMLSchema" xmlns:xsi="http://www.w3.org/1999/XMLSchema-instance"><soap:Body>`
+
+const responseFooter = `</soap:Body></soap:Envelope>
+`
+
+func escape(s string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
+
+func writeFault(w http.ResponseWriter, code, format string, args ...interface{}) {
+	w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+	w.WriteHeader(http.StatusInternalServerError)
+	fmt.Fprintf(w, "%s<soap:Fault><faultcode>soap:%s</faultcode><faultstring>%s</faultstring></soap:Fault>%s",
+		responseHeader, code, escape(fmt.Sprintf(format, args...)), responseFooter)
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var req request
+	if err := xml.Unmarshal(body, &req); err != nil {
+		writeFault(w, "Client", "Application failed during request deserialization: %v", err)
+		return
+	}
+	method := req.Body.Call.XMLName.Local
+	params := req.Body.Call.Params
+
+	s.mu.Lock()
+	s.calls = append(s.calls, method)
+	recording, recorded := s.Recordings[method]
+	s.mu.Unlock()
+
+	if recorded {
+		w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+		http.ServeFile(w, r, recording)
+		return
+	}
+
+	var inner string
+	switch method {
+	case "get_status":
+		inner, err = s.getStatus(params)
+	case "get_bug_log":
+		inner, err = s.getBugLog(params)
+	case "get_bugs":
+		inner, err = s.getBugs(params)
+	case "get_usertag":
+		inner, err = s.getUsertag(params)
+	default:
+		writeFault(w, "Client", "Failed to access class (%s): Can't locate %s", method, method)
+		return
+	}
+	if err != nil {
+		writeFault(w, "Server", "%v", err)
+		return
+	}
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	part, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type": []string{"text/xml; charset=utf-8"},
+		"Content-Id":   []string{"<main_envelope>"},
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprintf(part, `%s<%sResponse xmlns="%s">%s</%sResponse>%s`,
+		responseHeader, method, debbugs.Namespace, inner, method, responseFooter)
+	if err := mw.Close(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", fmt.Sprintf(`multipart/related; type="text/xml"; start="<main_envelope>"; boundary=%q`, mw.Boundary()))
+	w.Write(buf.Bytes())
+}
+
+func writeStringArray(buf *bytes.Buffer, name string, items []string) {
+	fmt.Fprintf(buf, `<%s soapenc:arrayType="xsd:string[%d]" xsi:type="soapenc:Array">`, name, len(items))
+	for _, item := range items {
+		fmt.Fprintf(buf, `<item xsi:type="xsd:string">%s</item>`, escape(item))
+	}
+	fmt.Fprintf(buf, "</%s>", name)
+}
+
+func writeIntArray(buf *bytes.Buffer, name string, items []int) {
+	fmt.Fprintf(buf, `<%s soapenc:arrayType="xsd:int[%d]" xsi:type="soapenc:Array">`, name, len(items))
+	for _, item := range items {
+		fmt.Fprintf(buf, `<item xsi:type="xsd:int">%d</item>`, item)
+	}
+	fmt.Fprintf(buf, "</%s>", name)
+}
+
+func (s *Server) getStatus(params []param) (string, error) {
+	var bugs []int
+	for _, p := range params {
+		ints, err := p.ints()
+		if err != nil {
+			return "", err
+		}
+		bugs = append(bugs, ints...)
+	}
+	var buf bytes.Buffer
+	buf.WriteString(`<s-gensym3 xsi:type="apachens:Map">`)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, bug := range bugs {
+		status, ok := s.Statuses[bug]
+		if !ok {
+			continue
+		}
+		archived := 0
+		if status.Archived {
+			archived = 1
+		}
+		fmt.Fprintf(&buf, `<item><key xsi:type="xsd:int">%d</key><value>`, bug)
+		fmt.Fprintf(&buf, `<bug_num xsi:type="xsd:int">%d</bug_num>`, bug)
+		for _, field := range []struct{ name, value string }{
+			{"package", status.Package},
+			{"source", status.Source},
+			{"subject", status.Subject},
+			{"originator", status.Originator},
+			{"owner", status.Owner},
+			{"severity", status.Severity},
+			{"tags", status.Tags},
+			{"done", status.Done},
+			{"forwarded", status.Forwarded},
+			{"pending", status.Pending},
+			{"mergedwith", status.MergedWith},
+		} {
+			fmt.Fprintf(&buf, `<%s xsi:type="xsd:string">%s</%s>`, field.name, escape(field.value), field.name)
+		}
+		fmt.Fprintf(&buf, `<archived xsi:type="xsd:int">%d</archived>`, archived)
+		fmt.Fprintf(&buf, `<date xsi:type="xsd:int">%d</date>`, status.Date)
+		fmt.Fprintf(&buf, `<log_modified xsi:type="xsd:int">%d</log_modified>`, status.LogModified)
+		writeStringArray(&buf, "found_versions", status.FoundVersions)
+		writeStringArray(&buf, "fixed_versions", status.FixedVersions)
+		buf.WriteString(`</value></item>`)
+	}
+	buf.WriteString(`</s-gensym3>`)
+	return buf.String(), nil
+}
+
+func (s *Server) getBugLog(params []param) (string, error) {
+	if len(params) != 1 {
+		return "", fmt.Errorf("get_bug_log takes exactly 1 parameter, got %d", len(params))
+	}
+	bugs, err := params[0].ints()
+	if err != nil {
+		return "", err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	items := s.BugLogs[bugs[0]]
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `<soapenc:Array soapenc:arrayType="xsd:ur-type[%d]" xsi:type="soapenc:Array">`, len(items))
+	for _, item := range items {
+		fmt.Fprintf(&buf, `<item><attachments soapenc:arrayType="xsd:ur-type[0]" xsi:type="soapenc:Array" />`+
+			`<body xsi:type="xsd:string">%s</body><header xsi:type="xsd:string">%s</header>`+
+			`<msg_num xsi:type="xsd:int">%d</msg_num></item>`,
+			escape(item.Body), escape(item.Header), item.MsgNum)
+	}
+	buf.WriteString(`</soapenc:Array>`)
+	return buf.String(), nil
+}
+
+func contains(list []string, s string) bool {
+	for _, entry := range list {
+		if entry == s {
+			return true
+		}
+	}
+	return false
+}
+
+func splitList(list string) []string {
+	return strings.FieldsFunc(list, func(r rune) bool { return r == ',' || r == ' ' })
+}
+
+// matches implements the subset of get_bugs keys which mergebot uses.
+func matches(status debbugs.Status, key, value string) (bool, error) {
+	switch key {
+	case "package":
+		return contains(splitList(status.Package), value), nil
+	case "src":
+		return contains(splitList(status.Source), value) ||
+			contains(splitList(status.Package), "src:"+value), nil
+	case "tag":
+		return contains(strings.Fields(status.Tags), value), nil
+	case "severity":
+		return status.Severity == value, nil
+	case "status":
+		switch value {
+		case "open":
+			return status.Done == "", nil
+		case "done":
+			return status.Done != "", nil
+		case "forwarded":
+			return status.Forwarded != "", nil
+		}
+		return false, fmt.Errorf("unknown status %q", value)
+	}
+	return false, fmt.Errorf("unsupported get_bugs key %q", key)
+}
+
+func (s *Server) getBugs(params []param) (string, error) {
+	if len(params)%2 != 0 {
+		return "", fmt.Errorf("get_bugs takes key/value pairs, got %d parameters", len(params))
+	}
+	query := make(map[string][]string)
+	for idx := 0; idx < len(params); idx += 2 {
+		key := params[idx].Value
+		query[key] = append(query[key], params[idx+1].values()...)
+		for _, value := range query[key] {
+			// Validate the query even if there are no bugs.
+			if _, err := matches(debbugs.Status{}, key, value); err != nil {
+				return "", err
+			}
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var result []int
+	for bug, status := range s.Statuses {
+		matchesAll := true
+		for key, values := range query {
+			matchesAny := false
+			for _, value := range values {
+				ok, err := matches(status, key, value)
+				if err != nil {
+					return "", err
+				}
+				matchesAny = matchesAny || ok
+			}
+			matchesAll = matchesAll && matchesAny
+		}
+		if matchesAll {
+			result = append(result, bug)
+		}
+	}
+	sort.Ints(result)
+	var buf bytes.Buffer
+	writeIntArray(&buf, "soapenc:Array", result)
+	return buf.String(), nil
+}
+
+func (s *Server) getUsertag(params []param) (string, error) {
+	if len(params) < 1 {
+		return "", fmt.Errorf("get_usertag takes at least 1 parameter, got %d", len(params))
+	}
+	email := params[0].Value
+	var tags []string
+	for _, p := range params[1:] {
+		tags = append(tags, p.values()...)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	usertags := s.Usertags[email]
+	var names []string
+	for name := range usertags {
+		if len(tags) == 0 || contains(tags, name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	var buf bytes.Buffer
+	buf.WriteString(`<s-gensym3 xsi:type="apachens:Map">`)
+	for _, name := range names {
+		writeIntArray(&buf, name, usertags[name])
+	}
+	buf.WriteString(`</s-gensym3>`)
+	return buf.String(), nil
+}
@@ -0,0 +1,172 @@
+package debbugs
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strings"
+)
+
+// Fault is a SOAP fault returned by the server, e.g. because of an
+// unknown method or invalid arguments.
+type Fault struct {
+	Code   string `xml:"faultcode"`
+	String string `xml:"faultstring"`
+}
+
+func (f *Fault) Error() string {
+	return fmt.Sprintf("SOAP fault %s: %s", f.Code, f.String)
+}
+
+const requestHeader = `<?xml version="1.0" encoding="UTF-8"?>
+<SOAP-ENV:Envelope
+  SOAP-ENV:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/"
+  xmlns:SOAP-ENC="http://schemas.xmlsoap.org/soap/encoding/"
+  xmlns:xsi="http://www.w3.org/1999/XMLSchema-instance"
+  xmlns:SOAP-ENV="http://schemas.xmlsoap.org/soap/envelope/"
+  xmlns:xsd="http://www.w3.org/1999/XMLSchema"
+>
+<SOAP-ENV:Body>
+`
+
+const requestFooter = `</SOAP-ENV:Body>
+</SOAP-ENV:Envelope>
+`
+
+func writeEscaped(buf *bytes.Buffer, s string) {
+	// xml.EscapeText only fails if the underlying writer fails, which
+	// bytes.Buffer never does.
+	xml.EscapeText(buf, []byte(s))
+}
+
+// encodeRequest returns a SOAP envelope calling method with params,
+// which must be of type int, string, []int or []string.
+func encodeRequest(method string, params ...interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(requestHeader)
+	fmt.Fprintf(&buf, "<ns1:%s xmlns:ns1=%q SOAP-ENC:root=\"1\">\n", method, Namespace)
+	for idx, param := range params {
+		name := fmt.Sprintf("v%d", idx+1)
+		switch v := param.(type) {
+		case int:
+			fmt.Fprintf(&buf, "<%s xsi:type=\"xsd:int\">%d</%s>\n", name, v, name)
+		case string:
+			fmt.Fprintf(&buf, "<%s xsi:type=\"xsd:string\">", name)
+			writeEscaped(&buf, v)
+			fmt.Fprintf(&buf, "</%s>\n", name)
+		case []int:
+			fmt.Fprintf(&buf, "<%s xsi:type=\"SOAP-ENC:Array\" SOAP-ENC:arrayType=\"xsd:int[%d]\">", name, len(v))
+			for _, item := range v {
+				fmt.Fprintf(&buf, "<item xsi:type=\"xsd:int\">%d</item>", item)
+			}
+			fmt.Fprintf(&buf, "</%s>\n", name)
+		case []string:
+			fmt.Fprintf(&buf, "<%s xsi:type=\"SOAP-ENC:Array\" SOAP-ENC:arrayType=\"xsd:string[%d]\">", name, len(v))
+			for _, item := range v {
+				buf.WriteString("<item xsi:type=\"xsd:string\">")
+				writeEscaped(&buf, item)
+				buf.WriteString("</item>")
+			}
+			fmt.Fprintf(&buf, "</%s>\n", name)
+		default:
+			return nil, fmt.Errorf("unsupported parameter type %T", param)
+		}
+	}
+	fmt.Fprintf(&buf, "</ns1:%s>\n", method)
+	buf.WriteString(requestFooter)
+	return buf.Bytes(), nil
+}
+
+type envelope struct {
+	XMLName xml.Name `xml:"http://schemas.xmlsoap.org/soap/envelope/ Envelope"`
+	Body    struct {
+		Fault    *Fault `xml:"Fault"`
+		Response struct {
+			XMLName xml.Name
+			Inner   []byte `xml:",innerxml"`
+		} `xml:",any"`
+	} `xml:"Body"`
+}
+
+// envelopeReader returns a reader for the SOAP envelope contained in
+// resp. For multipart/related responses (SOAP with attachments), this
+// is the root part as identified by the start parameter, or the first
+// part if start is not specified.
+func envelopeReader(resp *http.Response) (io.Reader, error) {
+	contentType := resp.Header.Get("Content-Type")
+	if contentType == "" {
+		return resp.Body, nil
+	}
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		return resp.Body, nil
+	}
+	start := params["start"]
+	mr := multipart.NewReader(resp.Body, params["boundary"])
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			return nil, fmt.Errorf("Root part %q not found in %s response", start, mediaType)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if start == "" || p.Header.Get("Content-Id") == start {
+			return p, nil
+		}
+	}
+}
+
+// call calls method with params and decodes the element contained in
+// the method’s response element into response.
+func (c *Client) call(method string, response interface{}, params ...interface{}) error {
+	body, err := encodeRequest(method, params...)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", c.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/xml; charset=utf-8")
+	req.Header.Set("SOAPAction", fmt.Sprintf("%q", Namespace+"#"+method))
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	r, err := envelopeReader(resp)
+	if err != nil {
+		return err
+	}
+	var env envelope
+	if err := xml.NewDecoder(r).Decode(&env); err != nil {
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("Unexpected HTTP status code: got %d, want %d", resp.StatusCode, http.StatusOK)
+		}
+		return err
+	}
+	// SOAP faults are returned with HTTP status code 500.
+	if env.Body.Fault != nil {
+		return env.Body.Fault
+	}
+	if got, want := resp.StatusCode, http.StatusOK; got != want {
+		return fmt.Errorf("Unexpected HTTP status code: got %d, want %d", got, want)
+	}
+	if got, want := env.Body.Response.XMLName.Local, method+"Response"; got != want {
+		return fmt.Errorf("Unexpected SOAP response element: got %q, want %q", got, want)
+	}
+	if len(bytes.TrimSpace(env.Body.Response.Inner)) == 0 {
+		// An empty response, e.g. get_bugs without any matching bugs.
+		return nil
+	}
+	return xml.Unmarshal(env.Body.Response.Inner, response)
+}
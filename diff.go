@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+// hunk is a hunk of a unified diff.
+type hunk struct {
+	// Header is the hunk header line, e.g. “@@ -3,6 +3,7 @@ Priority: extra\n”.
+	Header string
+
+	OldStart, OldLines int
+	NewStart, NewLines int
+
+	// Lines are the context, removed and added lines of the hunk,
+	// including their prefix and trailing newline.
+	Lines []string
+}
+
+// fileDiff is the part of a unified diff which modifies one file.
+type fileDiff struct {
+	// Header are the lines preceding the first hunk, e.g. “diff
+	// --git”, “index”, “---” and “+++” lines.
+	Header []string
+
+	// OldName and NewName are the file names from the “---” and
+	// “+++” lines (or the “diff --git” line) without timestamps, but
+	// still including their first path component, e.g. “a/”.
+	OldName, NewName string
+
+	Hunks []hunk
+}
+
+// Name returns the name of the file (relative to the top directory of
+// the source package, i.e. as patch -p1 sees it) which the diff
+// modifies.
+func (f fileDiff) Name() string {
+	name := f.NewName
+	if name == "/dev/null" || name == "" {
+		name = f.OldName
+	}
+	return stripComponent(name)
+}
+
+// Bytes returns the unified diff for this file.
+func (f fileDiff) Bytes() []byte {
+	var buf bytes.Buffer
+	for _, line := range f.Header {
+		buf.WriteString(line)
+	}
+	for _, h := range f.Hunks {
+		buf.WriteString(h.Header)
+		for _, line := range h.Lines {
+			buf.WriteString(line)
+		}
+	}
+	return buf.Bytes()
+}
+
+// diffHeaderName returns the file name of a “---” or “+++” line.
+func diffHeaderName(line string) string {
+	name := strings.TrimRight(line[len("--- "):], "\r\n")
+	return strings.SplitN(name, "\t", 2)[0]
+}
+
+// parseDiff parses the unified diff contained in data. Text preceding
+// the diff (e.g. the commit message of a git format-patch mail) is
+// returned as preamble, text following the last hunk (e.g. a
+// signature) is discarded. Hunk line counts are used to determine
+// where a hunk ends, see extractInlinePatch.
+func parseDiff(data []byte) (preamble string, files []fileDiff) {
+	lines := strings.SplitAfter(string(data), "\n")
+	var (
+		current          *fileDiff
+		sawDiffLine      bool
+		oldLeft, newLeft int
+	)
+	flush := func() {
+		if current != nil {
+			files = append(files, *current)
+			current = nil
+		}
+	}
+	for idx := 0; idx < len(lines); idx++ {
+		line := lines[idx]
+		if line == "" {
+			continue
+		}
+		if oldLeft > 0 || newLeft > 0 {
+			h := &current.Hunks[len(current.Hunks)-1]
+			consumed := true
+			switch {
+			case strings.HasPrefix(line, " "), line == "\n" || line == "\r\n":
+				oldLeft--
+				newLeft--
+			case strings.HasPrefix(line, "-"):
+				oldLeft--
+			case strings.HasPrefix(line, "+"):
+				newLeft--
+			case strings.HasPrefix(line, `\`):
+			default:
+				// The hunk is truncated.
+				oldLeft, newLeft = 0, 0
+				consumed = false
+			}
+			if consumed {
+				h.Lines = append(h.Lines, line)
+				continue
+			}
+		}
+
+		if current != nil && len(current.Hunks) > 0 && strings.HasPrefix(line, `\`) {
+			h := &current.Hunks[len(current.Hunks)-1]
+			h.Lines = append(h.Lines, line)
+			continue
+		}
+
+		if matches := hunkHeaderRe.FindStringSubmatch(line); matches != nil && current != nil {
+			h := hunk{Header: line}
+			h.OldLines = hunkLineCount(matches[1])
+			h.NewLines = hunkLineCount(matches[2])
+			fields := strings.Fields(line)
+			h.OldStart, _ = strconv.Atoi(strings.SplitN(strings.TrimPrefix(fields[1], "-"), ",", 2)[0])
+			h.NewStart, _ = strconv.Atoi(strings.SplitN(strings.TrimPrefix(fields[2], "+"), ",", 2)[0])
+			current.Hunks = append(current.Hunks, h)
+			oldLeft, newLeft = h.OldLines, h.NewLines
+			continue
+		}
+
+		if isDiffStart(lines, idx) {
+			isDiffLine := strings.HasPrefix(line, "diff ") || strings.HasPrefix(line, "Index: ")
+			if current == nil || len(current.Hunks) > 0 || (isDiffLine && sawDiffLine) {
+				flush()
+				current = &fileDiff{}
+				sawDiffLine = false
+			}
+			if isDiffLine {
+				sawDiffLine = true
+			}
+			if fields := strings.Fields(line); strings.HasPrefix(line, "diff --git ") && len(fields) == 4 {
+				current.OldName, current.NewName = fields[2], fields[3]
+			}
+		}
+
+		if current != nil && len(current.Hunks) == 0 && isFileHeader(line) {
+			if strings.HasPrefix(line, "--- ") {
+				current.OldName = diffHeaderName(line)
+			} else if strings.HasPrefix(line, "+++ ") {
+				current.NewName = diffHeaderName(line)
+			}
+			current.Header = append(current.Header, line)
+			continue
+		}
+
+		if current != nil {
+			// The diff ended, e.g. at a signature.
+			break
+		}
+		preamble += line
+	}
+	flush()
+	return preamble, files
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseDiffFormatPatch(t *testing.T) {
+	const path = "testdata/series/0002-Add-Homepage-field.patch"
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	preamble, files := parseDiff(contents)
+	if !strings.Contains(preamble, "Subject: [PATCH 2/2] Add Homepage field") {
+		t.Fatalf("Preamble does not contain the subject: %q", preamble)
+	}
+	if got, want := len(files), 1; got != want {
+		t.Fatalf("Unexpected number of files: got %d, want %d", got, want)
+	}
+	f := files[0]
+	if got, want := f.Name(), "debian/control"; got != want {
+		t.Fatalf("Incorrect file name: got %q, want %q", got, want)
+	}
+	if got, want := len(f.Hunks), 1; got != want {
+		t.Fatalf("Unexpected number of hunks: got %d, want %d", got, want)
+	}
+	if got, want := [4]int{f.Hunks[0].OldStart, f.Hunks[0].OldLines, f.Hunks[0].NewStart, f.Hunks[0].NewLines}, [4]int{1, 6, 1, 7}; got != want {
+		t.Fatalf("Incorrect hunk range: got %v, want %v", got, want)
+	}
+	// The git version signature must not be part of the diff.
+	diff := string(contents[strings.Index(string(contents), "diff --git"):strings.Index(string(contents), "-- \n2.8.1")])
+	if got, want := string(f.Bytes()), diff; got != want {
+		t.Fatalf("Incorrect diff: got %q, want %q", got, want)
+	}
+}
+
+func TestParseDiffMultipleFiles(t *testing.T) {
+	const diff = `Index: wit-2.31a/setup.sh
+===================================================================
+--- wit-2.31a.orig/setup.sh	2016-07-14 17:13:25.515286931 +0200
++++ wit-2.31a/setup.sh	2016-07-14 17:17:22.921655950 +0200
+@@ -1,2 +1,2 @@
+-date=$(date)
++date=$(date --date=@$SOURCE_DATE_EPOCH)
+ echo $date
+--- /dev/null
++++ b/debian/NEWS
+@@ -0,0 +1 @@
++wit (2.31a-3) unstable; urgency=medium
+diff --git a/debian/compat b/debian/compat
+deleted file mode 100644
+index ec63514..0000000
+--- a/debian/compat
++++ /dev/null
+@@ -1 +0,0 @@
+-9
+\ No newline at end of file
+diff --git a/debian/rules b/debian/rules
+old mode 100644
+new mode 100755
+`
+	_, files := parseDiff([]byte(diff))
+	var names []string
+	for _, f := range files {
+		names = append(names, f.Name())
+	}
+	if got, want := names, []string{"setup.sh", "debian/NEWS", "debian/compat", "debian/rules"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Incorrect file names: got %v, want %v", got, want)
+	}
+	var reassembled string
+	for _, f := range files {
+		reassembled += string(f.Bytes())
+	}
+	if got, want := reassembled, diff; got != want {
+		t.Fatalf("Reassembled diff differs: got %q, want %q", got, want)
+	}
+}
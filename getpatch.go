@@ -1,122 +1,503 @@
 package main
 
 import (
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
 	"encoding/base64"
-	"encoding/xml"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"mime"
 	"mime/multipart"
-	"net/http"
+	"mime/quotedprintable"
 	"net/mail"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
-)
+	"time"
 
-const (
-	soapAddress   = "https://bugs.debian.org/cgi-bin/soap.cgi"
-	soapNamespace = "Debbugs/SOAP"
+	"github.com/Debian/mergebot/debbugs"
 )
 
 type patch struct {
 	Author  string
 	Subject string
 	Data    []byte
+
+	// MsgNum is the number of the message (within the bug log) in
+	// which the patch was found.
+	MsgNum int
+
+	// Filename is the attachment’s file name, if any.
+	Filename string
+
+	// MediaType is the attachment’s MIME type, e.g. text/x-diff.
+	MediaType string
+
+	// Date is the date of the message in which the patch was found.
+	Date time.Time
+
+	// BaseVersions are the Debian versions against which the patch was
+	// presumably written, e.g. the versions in which the bug was found.
+	// They are used by the 3-way strategy of applyPatch.
+	BaseVersions []string
+
+	// Signer is the fingerprint of the key with which the message
+	// was PGP/MIME signed, if any. Trust is the result of verifying
+	// the signature against -keyring.
+	Signer string
+	Trust  trustLevel
+
+	// SigErr is the reason why the signature could not be verified,
+	// if Trust is trustError.
+	SigErr error
+}
+
+var (
+	// formatPatchFromRe matches the first line of a mail generated by
+	// git format-patch, e.g. “From 195fd00661db357a1243d6a5dde8fadf588fcaf8 Mon Sep 17 00:00:00 2001”.
+	formatPatchFromRe = regexp.MustCompile(`^From [0-9a-f]{40} `)
+
+	// formatPatchSubjectRe matches the subject of a mail generated by
+	// git format-patch, e.g. “Subject: [PATCH 1/2] Fix build”.
+	formatPatchSubjectRe = regexp.MustCompile(`(?m)^Subject: \[PATCH[^\]]*\]`)
+
+	// seriesFilenameRe matches the file name of a patch generated by
+	// git format-patch, e.g. “0002-Fix-build.patch”.
+	seriesFilenameRe = regexp.MustCompile(`^(\d+)-`)
+
+	// seriesSubjectRe matches the subject of a patch which is part of
+	// a series, e.g. “Subject: [PATCH 2/3] Fix build”.
+	seriesSubjectRe = regexp.MustCompile(`(?m)^Subject: \[PATCH[^\]]*?(\d+)/\d+\]`)
+
+	// hunkRe matches the header of a unified diff hunk.
+	hunkRe = regexp.MustCompile(`(?m)^@@ -\d+`)
+
+	// diffstatRe matches the diffstat summary line, e.g. “ 1 file changed, 1 insertion(+)”.
+	diffstatRe = regexp.MustCompile(`(?m)^ \d+ files? changed`)
+)
+
+// diffMediaTypes are the MIME types with which mail clients send
+// patches. Entities of these types contain nothing but the patch,
+// regardless of whether they are attachments or displayed inline (as
+// mutt does for text/x-diff).
+var diffMediaTypes = map[string]bool{
+	"text/x-diff":         true,
+	"text/x-patch":        true,
+	"text/x-debdiff":      true,
+	"text/diff":           true,
+	"application/x-patch": true,
+	"application/x-diff":  true,
 }
 
-func getMostRecentPatch(url, bug string) (patch, error) {
-	var result patch
-	// TODO: write a WSDL file and use a proper Go SOAP library? see https://golanglibs.com/top?q=soap
-	req := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
-<SOAP-ENV:Envelope
-  SOAP-ENV:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/"
-  xmlns:SOAP-ENC="http://schemas.xmlsoap.org/soap/encoding/"
-  xmlns:xsi="http://www.w3.org/1999/XMLSchema-instance"
-  xmlns:SOAP-ENV="http://schemas.xmlsoap.org/soap/envelope/"
-  xmlns:xsd="http://www.w3.org/1999/XMLSchema"
->
-<SOAP-ENV:Body>
-<ns1:get_bug_log xmlns:ns1="Debbugs/SOAP" SOAP-ENC:root="1">
-<v1 xsi:type="xsd:int">%s</v1>
-</ns1:get_bug_log>
-</SOAP-ENV:Body>
-</SOAP-ENV:Envelope>
-`, bug)
-	resp, err := http.Post(url, "", strings.NewReader(req))
+// isGitFormatPatch returns whether the patch was generated using git
+// format-patch, i.e. whether it can be applied using git am.
+func (p patch) isGitFormatPatch() bool {
+	if !formatPatchFromRe.Match(p.Data) {
+		return false
+	}
+	return formatPatchSubjectRe.Match(p.Data) || diffstatRe.Match(p.Data)
+}
+
+// looksLikePatch returns whether the patch contains at least one
+// unified diff hunk, so that e.g. attached build logs can be skipped.
+func (p patch) looksLikePatch() bool {
+	return hunkRe.Match(p.Data)
+}
+
+// seriesNumber returns the position of the patch within a series (as
+// indicated by its file name or subject), or 0 if it cannot be
+// determined.
+func (p patch) seriesNumber() int {
+	if matches := seriesFilenameRe.FindStringSubmatch(p.Filename); matches != nil {
+		n, _ := strconv.Atoi(matches[1])
+		return n
+	}
+	if matches := seriesSubjectRe.FindSubmatch(p.Data); matches != nil {
+		n, _ := strconv.Atoi(string(matches[1]))
+		return n
+	}
+	return 0
+}
+
+type bySeriesNumber []patch
+
+func (b bySeriesNumber) Len() int           { return len(b) }
+func (b bySeriesNumber) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b bySeriesNumber) Less(i, j int) bool { return b[i].seriesNumber() < b[j].seriesNumber() }
+
+type byMsgNum []debbugs.BugLogItem
+
+func (b byMsgNum) Len() int           { return len(b) }
+func (b byMsgNum) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byMsgNum) Less(i, j int) bool { return b[i].MsgNum < b[j].MsgNum }
+
+// getPatchCandidates returns all patches attached to any message of
+// the specified bug, newest message first.
+func getPatchCandidates(client *debbugs.Client, bug int) ([]patch, error) {
+	items, err := client.GetBugLog(bug)
 	if err != nil {
-		return result, err
+		return nil, err
 	}
-	if got, want := resp.StatusCode, http.StatusOK; got != want {
-		return result, fmt.Errorf("Unexpected HTTP status code: got %d, want %d", got, want)
+	return patchesFromItems(items)
+}
+
+// patchesFromItems returns all patches contained in items, newest
+// message first. Messages which cannot be parsed (e.g. because of a
+// corrupt attachment) are skipped, so that they do not hide the
+// patches of other messages. An error is only returned if no patches
+// remain.
+func patchesFromItems(items []debbugs.BugLogItem) ([]patch, error) {
+	// Debbugs does not guarantee any particular order, so sort by
+	// msg_num and look at the newest message first.
+	sort.Sort(byMsgNum(items))
+	var candidates []patch
+	var firstErr error
+	for i := len(items) - 1; i >= 0; i-- {
+		patches, err := patchesFromMessage(items[i])
+		if err != nil {
+			err = fmt.Errorf("message #%d: %v", items[i].MsgNum, err)
+			log.Printf("Skipping %v", err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		candidates = append(candidates, patches...)
+	}
+	if len(candidates) == 0 && firstErr != nil {
+		return nil, firstErr
 	}
+	return candidates, nil
+}
 
-	mediaType, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+// getPatchSeries returns the patches attached to message msgNum of the
+// specified bug, ordered by their series number. If msgNum is 0, the
+// most recent message with a patch is used. If attachment is not
+// empty, only the attachment with that file name is returned.
+func getPatchSeries(client *debbugs.Client, bug int, msgNum int, attachment string) ([]patch, error) {
+	candidates, err := getPatchCandidates(client, bug)
 	if err != nil {
-		return result, err
+		return nil, err
 	}
 
-	if !strings.HasPrefix(mediaType, "multipart/") {
-		return result, fmt.Errorf("Unexpected Content-Type: got %q, want multipart/*", resp.Header.Get("Content-Type"))
+	series, err := selectPatchSeries(candidates, fmt.Sprintf("bug #%d", bug), msgNum, attachment)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range series {
+		log.Printf("Using attachment %q (%s) from message #%d (see https://bugs.debian.org/%d#%d), signature: %v", p.Filename, p.MediaType, p.MsgNum, bug, p.MsgNum, p.Trust)
+	}
+	return series, nil
+}
+
+// selectPatchSeries implements the message and attachment selection
+// described in getPatchSeries. candidates must be ordered newest
+// message first, source is used in error messages (e.g. “bug #1”).
+func selectPatchSeries(candidates []patch, source string, msgNum int, attachment string) ([]patch, error) {
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("No MIME part with Content-Disposition == attachment found in %s", source)
+	}
+
+	var series []patch
+	for _, p := range candidates {
+		if msgNum != 0 && p.MsgNum != msgNum {
+			continue
+		}
+		if attachment != "" && p.Filename != attachment {
+			continue
+		}
+		// The candidates are ordered by message, so stop once all
+		// attachments of the first matching message were collected.
+		if len(series) > 0 && series[0].MsgNum != p.MsgNum {
+			break
+		}
+		if attachment == "" && !p.looksLikePatch() {
+			log.Printf("Skipping attachment %q (%s) from message #%d: does not look like a patch", p.Filename, p.MediaType, p.MsgNum)
+			continue
+		}
+		series = append(series, p)
+	}
+
+	if len(series) == 0 {
+		var list bytes.Buffer
+		for _, p := range candidates {
+			if p.Filename == "" {
+				fmt.Fprintf(&list, "\tmessage #%d: inline patch\n", p.MsgNum)
+				continue
+			}
+			fmt.Fprintf(&list, "\tmessage #%d: attachment %q (%s)\n", p.MsgNum, p.Filename, p.MediaType)
+		}
+		return nil, fmt.Errorf("No patch in %s matches message #%d, attachment %q. Available candidates:\n%s", source, msgNum, attachment, list.String())
+	}
+
+	sort.Stable(bySeriesNumber(series))
+	return series, nil
+}
+
+// patchesFromMessage returns all attachments of the specified message,
+// plus any patches pasted inline into text/plain parts or bodies and any
+// inline parts of a diff media type.
+func patchesFromMessage(item debbugs.BugLogItem) ([]patch, error) {
+	// Debbugs returns the header without the separating empty line.
+	m, err := mail.ReadMessage(strings.NewReader(strings.TrimRight(item.Header, "\n") + "\n\n" + item.Body))
+	if err != nil {
+		return nil, err
+	}
+
+	// The Date header is optional, in which case Date remains zero.
+	date, _ := m.Header.Date()
+	author, err := authorFromHeader(m.Header)
+	if err != nil {
+		log.Printf("message #%d: %v", item.MsgNum, err)
+	}
+	subject := decodeHeader(m.Header.Get("Subject"))
+
+	patches, err := patchesFromEntity(m.Header, m.Body, signature{Trust: trustUnsigned})
+	if err != nil {
+		return nil, err
+	}
+	for idx := range patches {
+		patches[idx].Date = date
+		patches[idx].Author = author
+		patches[idx].Subject = subject
+		patches[idx].MsgNum = item.MsgNum
+	}
+	return patches, nil
+}
+
+// mimeHeader is implemented by mail.Header and textproto.MIMEHeader.
+type mimeHeader interface {
+	Get(key string) string
+}
+
+// patchesFromEntity returns all patches contained in the MIME entity
+// with header h and body, descending into multipart entities. sig is
+// the signature covering the entity and is stored on all patches.
+func patchesFromEntity(h mimeHeader, body io.Reader, sig signature) ([]patch, error) {
+	newPatch := func(data []byte, filename, mediaType string) patch {
+		return patch{
+			Data:      data,
+			Filename:  filename,
+			MediaType: mediaType,
+			Signer:    sig.Signer,
+			Trust:     sig.Trust,
+			SigErr:    sig.Err,
+		}
+	}
+
+	contentType := h.Get("Content-Type")
+	if contentType == "" {
+		// See RFC 2045, section 5.2.
+		contentType = "text/plain"
+	}
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		log.Printf("Skipping MIME entity with invalid Content-Type header (%v)", err)
+		return nil, nil
+	}
+
+	if mediaType == "text/plain" {
+		data, err := decodeTransferEncoding(h.Get("Content-Transfer-Encoding"), body)
+		if err != nil {
+			return nil, err
+		}
+		if inline := extractInlinePatch(data); inline != nil {
+			return []patch{newPatch(inline, "", mediaType)}, nil
+		}
+		return nil, nil
 	}
 
-	var r struct {
-		XMLName xml.Name `xml:"http://schemas.xmlsoap.org/soap/envelope/ Envelope"`
-		Bugs    []struct {
-			XMLName xml.Name `xml:"Debbugs/SOAP item"`
-			MsgNum  int      `xml:"msg_num"`
-			Body    string   `xml:"body"`
-			Header  string   `xml:"header"`
-		} `xml:"Body>get_bug_logResponse>Array>item"`
+	if diffMediaTypes[mediaType] {
+		data, err := decodeTransferEncoding(h.Get("Content-Transfer-Encoding"), body)
+		if err != nil {
+			return nil, err
+		}
+		return []patch{newPatch(data, params["name"], mediaType)}, nil
 	}
 
-	if err := xml.NewDecoder(resp.Body).Decode(&r); err != nil {
-		return result, err
+	if mediaType == "multipart/signed" {
+		raw, err := ioutil.ReadAll(body)
+		if err != nil {
+			return nil, err
+		}
+		boundary := params["boundary"]
+		if protocol := params["protocol"]; !strings.EqualFold(protocol, "application/pgp-signature") {
+			// E.g. S/MIME (application/pkcs7-signature), which cannot be
+			// verified against -keyring.
+			log.Printf("Not verifying multipart/signed entity with protocol %q", protocol)
+			return patchesFromSignedPart(raw, boundary, sig)
+		}
+		signed, detached, err := splitSigned(raw, boundary)
+		if err == nil {
+			sig, err = verifySignature(signed, detached)
+		}
+		if err != nil {
+			// Whether unverifiable patches may be merged is up to
+			// checkSignaturePolicy.
+			log.Printf("Could not verify PGP/MIME signature: %v", err)
+			return patchesFromSignedPart(raw, boundary, signature{Trust: trustError, Err: err})
+		}
+		// The signed part is a MIME entity in its own right.
+		m, err := mail.ReadMessage(bytes.NewReader(signed))
+		if err != nil {
+			return nil, fmt.Errorf("multipart/signed: %v", err)
+		}
+		return patchesFromEntity(m.Header, m.Body, sig)
 	}
 
-	// TODO: handle bugs with multiple messages
-	if len(r.Bugs) != 1 {
-		log.Fatalf("len(r.Bugs) = %d", len(r.Bugs))
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		return nil, nil
 	}
 
-	body := r.Bugs[0].Body
-	mr := multipart.NewReader(strings.NewReader(body), params["boundary"])
+	var result []patch
+	mr := multipart.NewReader(body, params["boundary"])
 	for {
 		p, err := mr.NextPart()
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
-			return result, err
+			return nil, err
 		}
-		disposition, _, err := mime.ParseMediaType(p.Header.Get("Content-Disposition"))
-		if err != nil {
-			log.Printf("Skipping MIME part with invalid Content-Disposition header (%v)", err)
+		partType, partParams, _ := mime.ParseMediaType(p.Header.Get("Content-Type"))
+		if strings.HasPrefix(partType, "multipart/") {
+			patches, err := patchesFromEntity(p.Header, p, sig)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, patches...)
 			continue
 		}
+
+		disposition := "inline"
+		var dispositionParams map[string]string
+		if header := p.Header.Get("Content-Disposition"); header != "" {
+			disposition, dispositionParams, err = mime.ParseMediaType(header)
+			if err != nil {
+				log.Printf("Skipping MIME part with invalid Content-Disposition header (%v)", err)
+				continue
+			}
+		}
+
+		if disposition == "inline" && (partType == "text/plain" || partType == "") {
+			data, err := decodeTransferEncoding(p.Header.Get("Content-Transfer-Encoding"), p)
+			if err != nil {
+				return nil, err
+			}
+			if inline := extractInlinePatch(data); inline != nil {
+				result = append(result, newPatch(inline, "", "text/plain"))
+			}
+			continue
+		}
+
 		// TODO: is disposition always lowercase?
-		if got, want := disposition, "attachment"; got != want {
+		if got, want := disposition, "attachment"; got != want && !(disposition == "inline" && diffMediaTypes[partType]) {
 			log.Printf("Skipping MIME part with unexpected Content-Disposition: got %q, want %q", got, want)
 			continue
 		}
 
-		if p.Header.Get("Content-Transfer-Encoding") == "base64" {
-			m, err := mail.ReadMessage(strings.NewReader(r.Bugs[0].Header + body))
-			if err != nil {
-				return result, err
-			}
-			result.Author = m.Header.Get("From")
-			result.Subject = m.Header.Get("Subject")
-			encoded, err := ioutil.ReadAll(p)
+		data, err := decodeTransferEncoding(p.Header.Get("Content-Transfer-Encoding"), p)
+		if err != nil {
+			return nil, fmt.Errorf("attachment %q: %v", dispositionParams["filename"], err)
+		}
+		filename := dispositionParams["filename"]
+		if filename == "" {
+			filename = partParams["name"]
+		}
+		data, err = decompress(filename, partType, data)
+		if err != nil {
+			return nil, fmt.Errorf("attachment %q: %v", filename, err)
+		}
+		result = append(result, newPatch(data, filename, partType))
+	}
+
+	return result, nil
+}
+
+// patchesFromSignedPart returns the patches contained in the first
+// part of the multipart/signed entity with the specified raw body,
+// i.e. the part which the signature covers.
+func patchesFromSignedPart(raw []byte, boundary string, sig signature) ([]patch, error) {
+	p, err := multipart.NewReader(bytes.NewReader(raw), boundary).NextPart()
+	if err != nil {
+		return nil, fmt.Errorf("multipart/signed: %v", err)
+	}
+	return patchesFromEntity(p.Header, p, sig)
+}
+
+// decodeTransferEncoding reads r, decoding the specified
+// Content-Transfer-Encoding. Note that mime/multipart transparently
+// decodes quoted-printable parts and removes their
+// Content-Transfer-Encoding header.
+func decodeTransferEncoding(encoding string, r io.Reader) ([]byte, error) {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		return ioutil.ReadAll(base64.NewDecoder(base64.StdEncoding, r))
+	case "quoted-printable":
+		return ioutil.ReadAll(quotedprintable.NewReader(r))
+	case "", "7bit", "8bit", "binary":
+		return ioutil.ReadAll(r)
+	default:
+		return nil, fmt.Errorf("unsupported Content-Transfer-Encoding: %q", encoding)
+	}
+}
+
+// compression describes how to recognize and decompress a compressed
+// attachment.
+type compression struct {
+	extension  string
+	mediaTypes []string
+	decompress func(data []byte) ([]byte, error)
+}
+
+var compressions = []compression{
+	{
+		extension:  ".gz",
+		mediaTypes: []string{"application/gzip", "application/x-gzip"},
+		decompress: func(data []byte) ([]byte, error) {
+			r, err := gzip.NewReader(bytes.NewReader(data))
 			if err != nil {
-				return result, err
+				return nil, err
 			}
-			result.Data, err = base64.StdEncoding.DecodeString(string(encoded))
-			return result, err
-		} else {
-			log.Fatalf("unsupported Content-Transfer-Encoding: %q", p.Header.Get("Content-Transfer-Encoding"))
+			defer r.Close()
+			return ioutil.ReadAll(r)
+		},
+	},
+	{
+		extension:  ".bz2",
+		mediaTypes: []string{"application/x-bzip2", "application/x-bzip"},
+		decompress: func(data []byte) ([]byte, error) {
+			return ioutil.ReadAll(bzip2.NewReader(bytes.NewReader(data)))
+		},
+	},
+	{
+		extension:  ".xz",
+		mediaTypes: []string{"application/x-xz"},
+		decompress: func(data []byte) ([]byte, error) {
+			// The Go standard library does not include an xz decoder.
+			cmd := newCommand("xz", "--decompress", "--stdout")
+			cmd.Stdin = bytes.NewReader(data)
+			return cmd.Output()
+		},
+	},
+}
+
+// decompress transparently decompresses data if the attachment’s
+// media type or file name extension indicate a supported compression
+// format. Otherwise, data is returned unmodified.
+func decompress(filename, mediaType string, data []byte) ([]byte, error) {
+	for _, c := range compressions {
+		matches := strings.HasSuffix(strings.ToLower(filename), c.extension)
+		for _, t := range c.mediaTypes {
+			matches = matches || mediaType == t
+		}
+		if matches {
+			return c.decompress(data)
 		}
 	}
-
-	return result, fmt.Errorf("No MIME part with Content-Disposition == attachment found")
+	return data, nil
 }
@@ -3,9 +3,11 @@ package main
 import (
 	"bytes"
 	"io/ioutil"
-	"net/http"
-	"net/http/httptest"
+	"strings"
 	"testing"
+
+	"github.com/Debian/mergebot/debbugs"
+	"github.com/Debian/mergebot/debbugs/debbugstest"
 )
 
 const (
@@ -14,16 +16,16 @@ const (
 )
 
 func TestGetMostRecentPatch(t *testing.T) {
-	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", `multipart/related; type="text/xml"; start="<main_envelope>"; boundary="_----------=_146851316918670990"`)
-		http.ServeFile(w, r, goldenSoapPath)
-	}))
-	defer ts.Close()
+	srv := debbugstest.NewServer()
+	defer srv.Close()
+	srv.Recordings["get_bug_log"] = goldenSoapPath
+	client := debbugs.NewClient(srv.URL)
 
-	patch, err := getMostRecentPatch(ts.URL, "831331")
+	series, err := getPatchSeries(client, 831331, 0, "")
 	if err != nil {
 		t.Fatalf("Unexpected error: %v", err)
 	}
+	patch := series[0]
 
 	if got, want := patch.Author, "Chris Lamb <lamby@debian.org>"; got != want {
 		t.Fatalf("Incorrect patch author: got %q, want %q", got, want)
@@ -42,3 +44,270 @@ func TestGetMostRecentPatch(t *testing.T) {
 		t.Fatalf("Patch data parsed from %q does not match %q", goldenSoapPath, goldenPatchPath)
 	}
 }
+
+// TestGetMostRecentPatchMultipleMessages verifies that the newest
+// message which carries a patch is picked, even when newer messages
+// without patches exist and the BTS returns messages out of order.
+func TestGetMostRecentPatchMultipleMessages(t *testing.T) {
+	const (
+		soapPath  = "testdata/831331-multi.soap"
+		patchPath = "testdata/831331-v2.patch"
+	)
+	srv := debbugstest.NewServer()
+	defer srv.Close()
+	srv.Recordings["get_bug_log"] = soapPath
+	client := debbugs.NewClient(srv.URL)
+
+	series, err := getPatchSeries(client, 831331, 0, "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	patch := series[0]
+
+	if got, want := patch.Author, "Chris Lamb <lamby@debian.org>"; got != want {
+		t.Fatalf("Incorrect patch author: got %q, want %q", got, want)
+	}
+
+	if got, want := patch.Subject, "Bug#831331: wit: please make the build reproducible"; got != want {
+		t.Fatalf("Incorrect patch subject: got %q, want %q", got, want)
+	}
+
+	goldenPatch, err := ioutil.ReadFile(patchPath)
+	if err != nil {
+		t.Fatalf("Could not read golden patch data from %q for comparison: %v", patchPath, err)
+	}
+
+	if !bytes.Equal(patch.Data, goldenPatch) {
+		t.Fatalf("Patch data parsed from %q does not match %q", soapPath, patchPath)
+	}
+}
+
+func TestGetPatchSelectMessage(t *testing.T) {
+	srv := debbugstest.NewServer()
+	defer srv.Close()
+	srv.Recordings["get_bug_log"] = "testdata/831331-multi.soap"
+	client := debbugs.NewClient(srv.URL)
+
+	series, err := getPatchSeries(client, 831331, 5, "wit.diff.txt")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got, want := len(series), 1; got != want {
+		t.Fatalf("Unexpected number of patches: got %d, want %d", got, want)
+	}
+	patch := series[0]
+
+	if got, want := patch.MsgNum, 5; got != want {
+		t.Fatalf("Incorrect message number: got %d, want %d", got, want)
+	}
+
+	if got, want := patch.MediaType, "text/plain"; got != want {
+		t.Fatalf("Incorrect media type: got %q, want %q", got, want)
+	}
+
+	goldenPatch, err := ioutil.ReadFile(goldenPatchPath)
+	if err != nil {
+		t.Fatalf("Could not read golden patch data from %q for comparison: %v", goldenPatchPath, err)
+	}
+
+	if !bytes.Equal(patch.Data, goldenPatch) {
+		t.Fatalf("Patch data of message #5 does not match %q", goldenPatchPath)
+	}
+
+	_, err = getPatchSeries(client, 831331, 15, "")
+	if err == nil {
+		t.Fatalf("getPatchSeries unexpectedly succeeded for message #15, which contains no patch")
+	}
+	for _, want := range []string{
+		`message #10: attachment "wit.diff.txt" (text/x-diff)`,
+		`message #5: attachment "wit.diff.txt" (text/plain)`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Fatalf("Error message %q does not list candidate %q", err.Error(), want)
+		}
+	}
+}
+
+func TestIsGitFormatPatch(t *testing.T) {
+	for _, tt := range []struct {
+		path string
+		want bool
+	}{
+		{goldenPatchPath, false},
+		{"testdata/0001-Declare-Standards-Version-3.9.7.patch", true},
+	} {
+		data, err := ioutil.ReadFile(tt.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := (patch{Data: data}).isGitFormatPatch(); got != tt.want {
+			t.Errorf("isGitFormatPatch(%q): got %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestGetPatchSeries(t *testing.T) {
+	srv := debbugstest.NewServer()
+	defer srv.Close()
+	srv.Recordings["get_bug_log"] = "testdata/series.soap"
+	client := debbugs.NewClient(srv.URL)
+
+	series, err := getPatchSeries(client, 1, 0, "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// The attachments are in reverse order within the message, and
+	// the build log must be skipped.
+	goldenPaths := []string{
+		"testdata/series/0001-Declare-Standards-Version-3.9.7.patch",
+		"testdata/series/0002-Add-Homepage-field.patch",
+	}
+	if got, want := len(series), len(goldenPaths); got != want {
+		t.Fatalf("Unexpected number of patches: got %d, want %d", got, want)
+	}
+	for idx, goldenPath := range goldenPaths {
+		goldenPatch, err := ioutil.ReadFile(goldenPath)
+		if err != nil {
+			t.Fatalf("Could not read golden patch data from %q for comparison: %v", goldenPath, err)
+		}
+		if !bytes.Equal(series[idx].Data, goldenPatch) {
+			t.Fatalf("Patch %d (%q) does not match %q", idx+1, series[idx].Filename, goldenPath)
+		}
+	}
+}
+
+func TestGetMostRecentPatchTransferEncodings(t *testing.T) {
+	goldenPatch, err := ioutil.ReadFile(goldenPatchPath)
+	if err != nil {
+		t.Fatalf("Could not read golden patch data from %q for comparison: %v", goldenPatchPath, err)
+	}
+
+	for _, encoding := range []string{"quoted-printable", "7bit", "8bit"} {
+		soapPath := "testdata/831331-" + encoding + ".soap"
+		srv := debbugstest.NewServer()
+		srv.Recordings["get_bug_log"] = soapPath
+
+		series, err := getPatchSeries(debbugs.NewClient(srv.URL), 831331, 0, "")
+		srv.Close()
+		if err != nil {
+			t.Fatalf("%s: Unexpected error: %v", encoding, err)
+		}
+		patch := series[0]
+
+		if !bytes.Equal(patch.Data, goldenPatch) {
+			t.Fatalf("Patch data parsed from %q does not match %q: got %q", soapPath, goldenPatchPath, string(patch.Data))
+		}
+	}
+}
+
+func TestDecodeTransferEncodingUnsupported(t *testing.T) {
+	if _, err := decodeTransferEncoding("x-uuencode", strings.NewReader("")); err == nil {
+		t.Fatalf("decodeTransferEncoding unexpectedly succeeded for x-uuencode")
+	}
+}
+
+func TestGetMostRecentPatchInline(t *testing.T) {
+	const patchPath = "testdata/831331-inline.patch"
+	goldenPatch, err := ioutil.ReadFile(patchPath)
+	if err != nil {
+		t.Fatalf("Could not read golden patch data from %q for comparison: %v", patchPath, err)
+	}
+
+	for _, soapPath := range []string{
+		"testdata/831331-inline.soap",
+		"testdata/831331-inline-multipart.soap",
+	} {
+		srv := debbugstest.NewServer()
+		srv.Recordings["get_bug_log"] = soapPath
+
+		series, err := getPatchSeries(debbugs.NewClient(srv.URL), 831331, 0, "")
+		srv.Close()
+		if err != nil {
+			t.Fatalf("%s: Unexpected error: %v", soapPath, err)
+		}
+		patch := series[0]
+
+		if got, want := patch.Author, "Chris Lamb <lamby@debian.org>"; got != want {
+			t.Fatalf("%s: Incorrect patch author: got %q, want %q", soapPath, got, want)
+		}
+
+		if !bytes.Equal(patch.Data, goldenPatch) {
+			t.Fatalf("Patch data parsed from %q does not match %q: got %q", soapPath, patchPath, string(patch.Data))
+		}
+	}
+}
+
+func TestGetMostRecentPatchCompressed(t *testing.T) {
+	goldenPatch, err := ioutil.ReadFile(goldenPatchPath)
+	if err != nil {
+		t.Fatalf("Could not read golden patch data from %q for comparison: %v", goldenPatchPath, err)
+	}
+
+	for _, format := range []string{"gzip", "bzip2", "xz"} {
+		soapPath := "testdata/831331-" + format + ".soap"
+		srv := debbugstest.NewServer()
+		srv.Recordings["get_bug_log"] = soapPath
+
+		series, err := getPatchSeries(debbugs.NewClient(srv.URL), 831331, 0, "")
+		srv.Close()
+		if err != nil {
+			t.Fatalf("%s: Unexpected error: %v", format, err)
+		}
+		patch := series[0]
+
+		if !bytes.Equal(patch.Data, goldenPatch) {
+			t.Fatalf("Patch data parsed from %q does not match %q", soapPath, goldenPatchPath)
+		}
+	}
+}
+
+// multipartItem returns a bug log item with a single attachment.
+func multipartItem(msgNum int, partHeader, partBody string) debbugs.BugLogItem {
+	return debbugs.BugLogItem{
+		MsgNum: msgNum,
+		Header: "From: Chris Lamb <lamby@debian.org>\nSubject: wit: FTBFS\nMIME-Version: 1.0\nContent-Type: multipart/mixed; boundary=\"b\"\n",
+		Body:   "--b\nContent-Type: text/plain\n\nSee attachment.\n--b\n" + partHeader + "\n" + partBody + "\n--b--\n",
+	}
+}
+
+func TestPatchesFromItemsSkipsBrokenMessages(t *testing.T) {
+	const diff = "--- a/README\n+++ b/README\n@@ -1 +1 @@\n-min\n+max\n"
+	good := multipartItem(5, "Content-Type: text/x-diff\nContent-Disposition: attachment; filename=\"fix.diff\"\n", diff)
+	broken := multipartItem(7, "Content-Type: application/gzip\nContent-Disposition: attachment; filename=\"build.log.gz\"\nContent-Transfer-Encoding: base64\n", "H4sIAAAAAAAAA8tIzcnJVyjPL8pJAQ==")
+
+	candidates, err := patchesFromItems([]debbugs.BugLogItem{good, broken})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(candidates), 1; got != want {
+		t.Fatalf("Unexpected number of candidates: got %d, want %d", got, want)
+	}
+	if got, want := candidates[0].Filename, "fix.diff"; got != want {
+		t.Fatalf("Unexpected candidate: got %q, want %q", got, want)
+	}
+
+	if _, err := patchesFromItems([]debbugs.BugLogItem{broken}); err == nil || !strings.Contains(err.Error(), "message #7") {
+		t.Fatalf("patchesFromItems: got %v, want an error about message #7", err)
+	}
+}
+
+func TestPatchesFromMessageInlineDiff(t *testing.T) {
+	// mutt sends patches as inline parts of type text/x-diff.
+	const diff = "--- a/README\n+++ b/README\n@@ -1 +1 @@\n-min\n+max\n"
+	for _, partHeader := range []string{
+		"Content-Type: text/x-diff; charset=us-ascii\nContent-Disposition: inline; filename=\"fix.diff\"\n",
+		"Content-Type: text/x-patch\n",
+	} {
+		patches, err := patchesFromMessage(multipartItem(5, partHeader, diff))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := len(patches), 1; got != want {
+			t.Fatalf("Unexpected number of patches for %q: got %d, want %d", partHeader, got, want)
+		}
+		if got, want := string(patches[0].Data), diff; got != want {
+			t.Fatalf("Unexpected patch data for %q: got %q, want %q", partHeader, got, want)
+		}
+	}
+}
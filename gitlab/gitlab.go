@@ -0,0 +1,170 @@
+// gitlab is a minimal client for the GitLab REST API (version 4), as
+// used by https://salsa.debian.org. See
+// https://docs.gitlab.com/ee/api/README.html
+package gitlab
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// DefaultURL is the base URL of salsa.debian.org.
+const DefaultURL = "https://salsa.debian.org"
+
+// Client calls GitLab REST API methods on the server at URL.
+type Client struct {
+	// URL is the base URL of the GitLab instance, without the /api/v4
+	// suffix, e.g. DefaultURL.
+	URL string
+
+	// Token is sent as PRIVATE-TOKEN header, if not empty. Public
+	// projects can be accessed without a token.
+	Token string
+
+	// HTTPClient is used to make requests. Defaults to
+	// http.DefaultClient.
+	HTTPClient *http.Client
+}
+
+// NewClient returns a Client for the GitLab instance at url, e.g.
+// DefaultURL.
+func NewClient(url string) *Client {
+	return &Client{URL: strings.TrimSuffix(url, "/")}
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+// Error is returned for API responses with a non-2xx status code.
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("GitLab API: HTTP status %d: %s", e.StatusCode, e.Message)
+}
+
+// Project is a GitLab project, as returned by GET /projects/:id.
+type Project struct {
+	ID                int    `json:"id"`
+	PathWithNamespace string `json:"path_with_namespace"`
+	HTTPURLToRepo     string `json:"http_url_to_repo"`
+	WebURL            string `json:"web_url"`
+	DefaultBranch     string `json:"default_branch"`
+}
+
+// User is the (public) subset of a GitLab user.
+type User struct {
+	Username string `json:"username"`
+	Name     string `json:"name"`
+}
+
+// MergeRequest is a GitLab merge request, as returned by GET
+// /projects/:id/merge_requests/:merge_request_iid.
+type MergeRequest struct {
+	// IID is the merge request number within its (target) project.
+	IID         int    `json:"iid"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+
+	// State is one of opened, closed, locked or merged.
+	State  string `json:"state"`
+	Author User   `json:"author"`
+
+	SourceProjectID int    `json:"source_project_id"`
+	SourceBranch    string `json:"source_branch"`
+	TargetProjectID int    `json:"target_project_id"`
+	TargetBranch    string `json:"target_branch"`
+
+	// SHA is the commit id of the merge request’s head.
+	SHA    string `json:"sha"`
+	WebURL string `json:"web_url"`
+}
+
+// HeadRef returns the ref under which GitLab makes the head of the
+// merge request available in the target project’s repository.
+func (mr MergeRequest) HeadRef() string {
+	return fmt.Sprintf("refs/merge-requests/%d/head", mr.IID)
+}
+
+func (c *Client) get(path string, result interface{}) error {
+	req, err := http.NewRequest("GET", c.URL+"/api/v4"+path, nil)
+	if err != nil {
+		return err
+	}
+	if c.Token != "" {
+		req.Header.Set("PRIVATE-TOKEN", c.Token)
+	}
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		var apiErr struct {
+			Message string `json:"message"`
+		}
+		message := strings.TrimSpace(string(body))
+		if err := json.Unmarshal(body, &apiErr); err == nil && apiErr.Message != "" {
+			message = apiErr.Message
+		}
+		return &Error{StatusCode: resp.StatusCode, Message: message}
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// projectPath returns the URL path component identifying project,
+// which is either a numeric id or a path such as “debian/wit”.
+func projectPath(project string) string {
+	return "/projects/" + url.QueryEscape(project)
+}
+
+// GetProject returns the specified project, identified by numeric id
+// or path (e.g. “debian/wit”).
+func (c *Client) GetProject(project string) (Project, error) {
+	var result Project
+	return result, c.get(projectPath(project), &result)
+}
+
+// GetMergeRequest returns merge request iid of the specified project.
+func (c *Client) GetMergeRequest(project string, iid int) (MergeRequest, error) {
+	var result MergeRequest
+	return result, c.get(fmt.Sprintf("%s/merge_requests/%d", projectPath(project), iid), &result)
+}
+
+// mergeRequestURLRe matches the web URL of a merge request, e.g.
+// https://salsa.debian.org/debian/wit/-/merge_requests/3 (older
+// GitLab versions omit the “-/” component).
+var mergeRequestURLRe = regexp.MustCompile(`^(https?://[^/]+)/(.+?)(?:/-)?/merge_requests/(\d+)/?$`)
+
+// mergeRequestRefRe matches the short reference of a merge request,
+// e.g. debian/wit!3.
+var mergeRequestRefRe = regexp.MustCompile(`^([^!]+)!(\d+)$`)
+
+// ParseMergeRequest parses a merge request specified either by its web
+// URL or as “project!iid” (relative to defaultURL) and returns the base
+// URL of the GitLab instance, the project path and the merge request
+// iid.
+func ParseMergeRequest(mr, defaultURL string) (baseURL, project string, iid int, err error) {
+	if matches := mergeRequestURLRe.FindStringSubmatch(mr); matches != nil {
+		iid, _ = strconv.Atoi(matches[3])
+		return matches[1], matches[2], iid, nil
+	}
+	if matches := mergeRequestRefRe.FindStringSubmatch(mr); matches != nil {
+		iid, _ = strconv.Atoi(matches[2])
+		return defaultURL, matches[1], iid, nil
+	}
+	return "", "", 0, fmt.Errorf("Invalid merge request %q: expected e.g. %s/debian/wit/-/merge_requests/3 or debian/wit!3", mr, DefaultURL)
+}
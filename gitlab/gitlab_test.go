@@ -0,0 +1,100 @@
+package gitlab_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Debian/mergebot/gitlab"
+	"github.com/Debian/mergebot/gitlab/gitlabtest"
+)
+
+func TestGetMergeRequest(t *testing.T) {
+	srv := gitlabtest.NewServer()
+	defer srv.Close()
+	srv.Projects["debian/wit"] = gitlab.Project{
+		ID:                42,
+		PathWithNamespace: "debian/wit",
+		HTTPURLToRepo:     "https://salsa.debian.org/debian/wit.git",
+	}
+	srv.MergeRequests["debian/wit"] = map[int]gitlab.MergeRequest{
+		3: {
+			IID:          3,
+			Title:        "Make the build reproducible",
+			State:        "opened",
+			Author:       gitlab.User{Username: "lamby", Name: "Chris Lamb"},
+			TargetBranch: "master",
+		},
+	}
+	client := gitlab.NewClient(srv.URL)
+
+	project, err := client.GetProject("debian/wit")
+	if err != nil {
+		t.Fatalf("GetProject: %v", err)
+	}
+	if got, want := project, srv.Projects["debian/wit"]; !reflect.DeepEqual(got, want) {
+		t.Fatalf("GetProject: got %+v, want %+v", got, want)
+	}
+
+	mr, err := client.GetMergeRequest("debian/wit", 3)
+	if err != nil {
+		t.Fatalf("GetMergeRequest: %v", err)
+	}
+	if got, want := mr, srv.MergeRequests["debian/wit"][3]; !reflect.DeepEqual(got, want) {
+		t.Fatalf("GetMergeRequest: got %+v, want %+v", got, want)
+	}
+	if got, want := mr.HeadRef(), "refs/merge-requests/3/head"; got != want {
+		t.Fatalf("Incorrect head ref: got %q, want %q", got, want)
+	}
+
+	// Projects can be referred to by numeric id, too.
+	if _, err := client.GetMergeRequest("42", 3); err != nil {
+		t.Fatalf("GetMergeRequest: %v", err)
+	}
+
+	if got, want := srv.Calls(), []string{
+		"/api/v4/projects/debian%2Fwit",
+		"/api/v4/projects/debian%2Fwit/merge_requests/3",
+		"/api/v4/projects/42/merge_requests/3",
+	}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Unexpected calls: got %v, want %v", got, want)
+	}
+}
+
+func TestGetMergeRequestNotFound(t *testing.T) {
+	srv := gitlabtest.NewServer()
+	defer srv.Close()
+
+	_, err := gitlab.NewClient(srv.URL).GetMergeRequest("debian/wit", 3)
+	apiErr, ok := err.(*gitlab.Error)
+	if !ok {
+		t.Fatalf("Unexpected error: got %v (%T), want a *gitlab.Error", err, err)
+	}
+	if got, want := apiErr.StatusCode, 404; got != want {
+		t.Fatalf("Incorrect status code: got %d, want %d", got, want)
+	}
+}
+
+func TestParseMergeRequest(t *testing.T) {
+	for _, tt := range []struct {
+		mr      string
+		baseURL string
+		project string
+		iid     int
+	}{
+		{"https://salsa.debian.org/debian/wit/-/merge_requests/3", "https://salsa.debian.org", "debian/wit", 3},
+		{"https://salsa.debian.org/go-team/packages/wit/merge_requests/12/", "https://salsa.debian.org", "go-team/packages/wit", 12},
+		{"debian/wit!3", gitlab.DefaultURL, "debian/wit", 3},
+	} {
+		baseURL, project, iid, err := gitlab.ParseMergeRequest(tt.mr, gitlab.DefaultURL)
+		if err != nil {
+			t.Fatalf("ParseMergeRequest(%q): %v", tt.mr, err)
+		}
+		if baseURL != tt.baseURL || project != tt.project || iid != tt.iid {
+			t.Fatalf("ParseMergeRequest(%q): got (%q, %q, %d), want (%q, %q, %d)", tt.mr, baseURL, project, iid, tt.baseURL, tt.project, tt.iid)
+		}
+	}
+
+	if _, _, _, err := gitlab.ParseMergeRequest("https://salsa.debian.org/debian/wit", gitlab.DefaultURL); err == nil {
+		t.Fatalf("ParseMergeRequest unexpectedly accepted a project URL")
+	}
+}
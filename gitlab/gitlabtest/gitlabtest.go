@@ -0,0 +1,118 @@
+// gitlabtest provides a fake GitLab REST API server for use in tests.
+package gitlabtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Debian/mergebot/gitlab"
+)
+
+// Server is a fake GitLab REST API server. Responses are computed from
+// Projects and MergeRequests.
+type Server struct {
+	*httptest.Server
+
+	// Projects maps project path (e.g. debian/wit) to project.
+	Projects map[string]gitlab.Project
+
+	// MergeRequests maps project path to merge request iid to merge
+	// request.
+	MergeRequests map[string]map[int]gitlab.MergeRequest
+
+	mu    sync.Mutex
+	calls []string
+}
+
+// NewServer starts and returns a new Server. The caller should call
+// Close when finished, to shut it down.
+func NewServer() *Server {
+	s := &Server{
+		Projects:      make(map[string]gitlab.Project),
+		MergeRequests: make(map[string]map[int]gitlab.MergeRequest),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// Calls returns the (escaped) paths which were requested so far, in
+// order.
+func (s *Server) Calls() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.calls...)
+}
+
+func writeError(w http.ResponseWriter, code int, format string, args ...interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"message": fmt.Sprintf(format, args...)})
+}
+
+// project returns the path of the project identified by id, which is
+// either a numeric id or an (unescaped) path.
+func (s *Server) project(id string) (string, bool) {
+	if _, ok := s.Projects[id]; ok {
+		return id, true
+	}
+	if n, err := strconv.Atoi(id); err == nil {
+		for path, p := range s.Projects {
+			if p.ID == n {
+				return path, true
+			}
+		}
+	}
+	return "", false
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.EscapedPath()
+	s.mu.Lock()
+	s.calls = append(s.calls, path)
+	s.mu.Unlock()
+
+	if r.Method != "GET" {
+		writeError(w, http.StatusMethodNotAllowed, "405 Method Not Allowed")
+		return
+	}
+	// Project paths must be escaped, so a project path never contains
+	// a literal slash: /api/v4/projects/:id[/merge_requests/:iid]
+	parts := strings.Split(strings.TrimPrefix(path, "/api/v4/projects/"), "/")
+	if !strings.HasPrefix(path, "/api/v4/projects/") || (len(parts) != 1 && len(parts) != 3) {
+		writeError(w, http.StatusNotFound, "404 Not Found")
+		return
+	}
+	id, err := url.QueryUnescape(parts[0])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "400 Bad Request: %v", err)
+		return
+	}
+	project, ok := s.project(id)
+	if !ok {
+		writeError(w, http.StatusNotFound, "404 Project Not Found")
+		return
+	}
+
+	var result interface{} = s.Projects[project]
+	if len(parts) == 3 {
+		iid, err := strconv.Atoi(parts[2])
+		if parts[1] != "merge_requests" || err != nil {
+			writeError(w, http.StatusNotFound, "404 Not Found")
+			return
+		}
+		mr, ok := s.MergeRequests[project][iid]
+		if !ok {
+			writeError(w, http.StatusNotFound, "404 Not found")
+			return
+		}
+		result = mr
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
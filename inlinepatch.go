@@ -0,0 +1,134 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// hunkHeaderRe matches a unified diff hunk header, e.g.
+// “@@ -3,6 +3,7 @@ Priority: extra”. Omitted line counts default to 1.
+var hunkHeaderRe = regexp.MustCompile(`^@@ -\d+(?:,(\d+))? \+\d+(?:,(\d+))? @@`)
+
+// fileHeaderPrefixes are the prefixes of lines which may precede the
+// hunks of a file in a unified diff, as generated by diff(1), git and
+// quilt.
+var fileHeaderPrefixes = []string{
+	"diff ",
+	"index ",
+	"Index: ",
+	"===",
+	"--- ",
+	"+++ ",
+	"new file mode ",
+	"deleted file mode ",
+	"old mode ",
+	"new mode ",
+	"similarity index ",
+	"dissimilarity index ",
+	"rename from ",
+	"rename to ",
+	"copy from ",
+	"copy to ",
+}
+
+func isFileHeader(line string) bool {
+	for _, prefix := range fileHeaderPrefixes {
+		if strings.HasPrefix(line, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// isDiffStart returns whether lines[idx] starts a unified diff.
+func isDiffStart(lines []string, idx int) bool {
+	line := lines[idx]
+	if strings.HasPrefix(line, "diff --git ") || strings.HasPrefix(line, "Index: ") {
+		return true
+	}
+	return strings.HasPrefix(line, "--- ") &&
+		idx+1 < len(lines) &&
+		strings.HasPrefix(lines[idx+1], "+++ ")
+}
+
+func hunkLineCount(count string) int {
+	if count == "" {
+		return 1
+	}
+	n, _ := strconv.Atoi(count)
+	return n
+}
+
+// extractInlinePatch returns the unified diff contained in body (e.g.
+// a mail which contains prose, a pasted diff and a signature), or nil
+// if body does not contain a diff. Hunk line counts are used to
+// determine where the diff ends, so that a trailing signature
+// separator (“-- ”) is not mistaken for a removed line.
+func extractInlinePatch(body []byte) []byte {
+	lines := strings.SplitAfter(string(body), "\n")
+	start := -1
+	for idx := range lines {
+		if isDiffStart(lines, idx) {
+			start = idx
+			break
+		}
+	}
+	if start == -1 {
+		return nil
+	}
+
+	var (
+		end              = start
+		oldLeft, newLeft int
+		sawHunk          bool
+	)
+	for idx := start; idx < len(lines); idx++ {
+		line := lines[idx]
+		if oldLeft > 0 || newLeft > 0 {
+			switch {
+			case strings.HasPrefix(line, " "),
+				// Some mail clients strip the trailing space of
+				// empty context lines.
+				line == "\n" || line == "\r\n":
+				oldLeft--
+				newLeft--
+			case strings.HasPrefix(line, "-"):
+				oldLeft--
+			case strings.HasPrefix(line, "+"):
+				newLeft--
+			case strings.HasPrefix(line, `\`):
+				// “\ No newline at end of file”
+			default:
+				// The hunk is truncated, stop before it.
+				return []byte(strings.Join(lines[start:end], ""))
+			}
+			if oldLeft <= 0 && newLeft <= 0 {
+				end = idx + 1
+			}
+			continue
+		}
+
+		if strings.HasPrefix(line, `\`) {
+			end = idx + 1
+			continue
+		}
+
+		if matches := hunkHeaderRe.FindStringSubmatch(line); matches != nil {
+			oldLeft = hunkLineCount(matches[1])
+			newLeft = hunkLineCount(matches[2])
+			sawHunk = true
+			continue
+		}
+
+		if isFileHeader(line) {
+			continue
+		}
+
+		break
+	}
+	if !sawHunk || end == start {
+		return nil
+	}
+	return []byte(strings.Join(lines[start:end], ""))
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExtractInlinePatch(t *testing.T) {
+	const diff = `diff --git a/debian/control b/debian/control
+index fe3b90c..e64d529 100644
+--- a/debian/control
++++ b/debian/control
+@@ -3,6 +3,7 @@ Priority: extra
+ Section: devel
+ Build-Depends: debhelper (>= 9)
+ Maintainer: Michael Stapelberg <stapelberg@debian.org>
++Standards-Version: 3.9.7
+ 
+ Package: min
+ Architecture: any
+`
+	for _, tt := range []struct {
+		name string
+		body string
+		want string
+	}{
+		{
+			name: "no diff",
+			body: "Hi,\n\nplease fix this bug.\n\n-- \nBest regards,\nMichael\n",
+			want: "",
+		},
+		{
+			name: "prose and signature",
+			body: "Hi,\n\npatch below:\n\n" + diff + "\nThanks,\n\n-- \nChris\n",
+			want: diff,
+		},
+		{
+			name: "signature directly after the last hunk",
+			body: diff + "-- \n2.8.1\n",
+			want: diff,
+		},
+		{
+			name: "stripped trailing space on empty context line",
+			body: "patch:\n" + strings.Replace(diff, "\n \n", "\n\n", 1) + "-- \nChris\n",
+			want: strings.Replace(diff, "\n \n", "\n\n", 1),
+		},
+		{
+			name: "multiple files",
+			body: "patch:\n" + diff + "--- a/debian/compat\n+++ b/debian/compat\n@@ -1 +1 @@\n-9\n+10\n\\ No newline at end of file\nbye\n",
+			want: diff + "--- a/debian/compat\n+++ b/debian/compat\n@@ -1 +1 @@\n-9\n+10\n\\ No newline at end of file\n",
+		},
+	} {
+		if got := string(extractInlinePatch([]byte(tt.body))); got != tt.want {
+			t.Errorf("%s: extractInlinePatch: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
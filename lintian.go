@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/Debian/mergebot/changelog"
+)
+
+var (
+	lintianEnabled = flag.Bool("lintian",
+		true,
+		"Run lintian on the built package and report the tags which the previous version did not have.")
+
+	failOnNewLintianErrors = flag.Bool("fail_on_new_lintian_errors",
+		false,
+		"Fail when lintian reports errors which the previous version did not have.")
+)
+
+// lintianTag is a single tag emitted by lintian, e.g.
+// “W: min: binary-without-manpage usr/bin/min”.
+type lintianTag struct {
+	Severity string
+	Package  string
+	// Type is empty for binary packages, otherwise e.g. “source”.
+	Type string
+	Tag  string
+	Info string
+}
+
+func (t lintianTag) String() string {
+	s := t.Severity + ": " + t.Package
+	if t.Type != "" {
+		s += " " + t.Type
+	}
+	s += ": " + t.Tag
+	if t.Info != "" {
+		s += " " + t.Info
+	}
+	return s
+}
+
+// lintianTagRe matches a tag line of lintian’s output. Lines of other
+// kinds (e.g. “N:” for explanations) do not match.
+var lintianTagRe = regexp.MustCompile(`^([EWI]): ([^\s:]+)(?: (source|udeb|changes|buildinfo))?: (\S+)(?: (.*))?$`)
+
+// parseLintian returns the error, warning and info tags contained in
+// lintian’s output. Tags of other severities (pedantic, experimental,
+// overridden) are skipped.
+func parseLintian(output []byte) ([]lintianTag, error) {
+	var tags []lintianTag
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		matches := lintianTagRe.FindStringSubmatch(scanner.Text())
+		if matches == nil {
+			continue
+		}
+		tags = append(tags, lintianTag{
+			Severity: matches[1],
+			Package:  matches[2],
+			Type:     matches[3],
+			Tag:      matches[4],
+			Info:     matches[5],
+		})
+	}
+	return tags, scanner.Err()
+}
+
+// checkLintian verifies that lintian is installed, so that mergebot
+// fails before cloning and building.
+func checkLintian() error {
+	if _, err := exec.LookPath("lintian"); err != nil {
+		return fmt.Errorf("lintian is not installed, install it or specify -lintian=false: %v", err)
+	}
+	return nil
+}
+
+// lintian runs lintian on files and returns the tags it emits.
+func lintian(files ...string) ([]lintianTag, error) {
+	// lintian exits with a non-zero status when it finds errors, which
+	// must not be confused with lintian failing to run.
+	args := append([]string{"--fail-on=none", "--display-info"}, files...)
+	output, err := newCommand("lintian", args...).Output()
+	if err != nil {
+		return nil, err
+	}
+	return parseLintian(output)
+}
+
+// versionStrings returns the forms in which version appears in file
+// names and tag infos, longest first: the full version, the version
+// without epoch and the upstream version.
+func versionStrings(version string) []string {
+	strs := []string{version}
+	if idx := strings.Index(version, ":"); idx > -1 {
+		version = version[idx+1:]
+		strs = append(strs, version)
+	}
+	if upstream, revision := splitVersion(version); revision != "" {
+		strs = append(strs, upstream)
+	}
+	return strs
+}
+
+// lintianKey identifies tag (emitted for version) regardless of its
+// severity and of the package version, which tag infos often contain,
+// e.g. in file names.
+func lintianKey(tag lintianTag, version string) lintianTag {
+	info := tag.Info
+	for _, v := range versionStrings(version) {
+		info = strings.Replace(info, v, "<version>", -1)
+	}
+	return lintianTag{Package: tag.Package, Type: tag.Type, Tag: tag.Tag, Info: info}
+}
+
+// newLintianTags returns the tags which are contained in current (of
+// currentVersion), but not in previous (of previousVersion). Tags are
+// compared including their info, so that e.g. an additional
+// binary-without-manpage tag for a new program is new.
+func newLintianTags(current []lintianTag, currentVersion string, previous []lintianTag, previousVersion string) []lintianTag {
+	known := make(map[lintianTag]bool)
+	for _, tag := range previous {
+		known[lintianKey(tag, previousVersion)] = true
+	}
+	var added []lintianTag
+	for _, tag := range current {
+		if !known[lintianKey(tag, currentVersion)] {
+			added = append(added, tag)
+		}
+	}
+	return added
+}
+
+// lintianReport lists the lintian tags of the built package which the
+// previous version did not have.
+type lintianReport struct {
+	Errors   []lintianTag
+	Warnings []lintianTag
+	Info     []lintianTag
+
+	// Baseline is the version against whose tags the tags of the built
+	// package were compared, or empty if the previous version could not
+	// be checked, in which case all tags are reported.
+	Baseline string
+}
+
+func newLintianReport(tags []lintianTag, baseline string) lintianReport {
+	r := lintianReport{Baseline: baseline}
+	for _, tag := range tags {
+		switch tag.Severity {
+		case "E":
+			r.Errors = append(r.Errors, tag)
+		case "W":
+			r.Warnings = append(r.Warnings, tag)
+		case "I":
+			r.Info = append(r.Info, tag)
+		}
+	}
+	return r
+}
+
+func plural(n int, singular, plural string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, singular)
+	}
+	return fmt.Sprintf("%d %s", n, plural)
+}
+
+// Lines returns a human-readable summary of the report, followed by
+// the tags.
+func (r lintianReport) Lines() []string {
+	counts := fmt.Sprintf("%s, %s, %s",
+		plural(len(r.Errors), "error", "errors"),
+		plural(len(r.Warnings), "warning", "warnings"),
+		plural(len(r.Info), "info tag", "info tags"))
+	var lines []string
+	if r.Baseline != "" {
+		lines = append(lines, fmt.Sprintf("lintian: %s not present in version %s", counts, r.Baseline))
+	} else {
+		lines = append(lines, fmt.Sprintf("lintian: %s (the previous version could not be checked)", counts))
+	}
+	for _, tags := range [][]lintianTag{r.Errors, r.Warnings, r.Info} {
+		for _, tag := range tags {
+			lines = append(lines, "  "+tag.String())
+		}
+	}
+	return lines
+}
+
+// binaryPackages returns the names of the binary packages in debs.
+func binaryPackages(debs []string) []string {
+	names := make([]string, len(debs))
+	for idx, deb := range debs {
+		names[idx] = strings.SplitN(filepath.Base(deb), "_", 2)[0]
+	}
+	return names
+}
+
+// previousLintianTags downloads version of the source package source
+// (if withSource) and of the binary packages binaries into dir using
+// apt-get, and returns the tags which lintian emits for them. Binary
+// packages which cannot be downloaded (e.g. because they were
+// introduced since version) are skipped.
+func previousLintianTags(dir, source, version string, withSource bool, binaries []string) ([]lintianTag, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	if withSource {
+		cmd := newCommand("apt-get", "source", "--download-only", source+"="+version)
+		cmd.Dir = dir
+		if err := cmd.Run(); err != nil {
+			return nil, err
+		}
+	}
+	for _, binary := range binaries {
+		cmd := newCommand("apt-get", "download", binary+"="+version)
+		cmd.Dir = dir
+		if err := cmd.Run(); err != nil {
+			log.Printf("Not comparing lintian tags of binary package %q: %v", binary, err)
+		}
+	}
+	var files []string
+	for _, pattern := range []string{"*.dsc", "*.deb", "*.udeb"} {
+		matches, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, matches...)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("No packages of version %s could be downloaded", version)
+	}
+	return lintian(files...)
+}
+
+// runLintian runs lintian on the .changes file of the package built
+// from checkoutDir and compares its tags to those of the version
+// preceding it in debian/changelog, which is downloaded into
+// tempDir/previous. The previous version is checked using the same
+// kinds of packages (source, if built, and binary packages), but as
+// it has no .changes and .buildinfo files, their tags are always new.
+func runLintian(tempDir, checkoutDir string, result buildResult) (lintianReport, error) {
+	current, err := lintian(result.Changes)
+	if err != nil {
+		return lintianReport{}, err
+	}
+
+	c, err := changelog.ReadFile(filepath.Join(checkoutDir, "debian", "changelog"))
+	if err != nil {
+		return lintianReport{}, err
+	}
+	if len(c.Entries) < 2 {
+		log.Printf("No previous version in debian/changelog, reporting all lintian tags")
+		return newLintianReport(current, ""), nil
+	}
+	currentVersion, previousVersion := c.Entries[0].Version, c.Entries[1].Version
+	previous, err := previousLintianTags(filepath.Join(tempDir, "previous"), c.Entries[0].Source, previousVersion, result.Dsc != "", binaryPackages(result.Debs))
+	if err != nil {
+		log.Printf("Could not run lintian on version %s, reporting all lintian tags: %v", previousVersion, err)
+		return newLintianReport(current, ""), nil
+	}
+	return newLintianReport(newLintianTags(current, currentVersion, previous, previousVersion), previousVersion), nil
+}
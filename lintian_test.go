@@ -0,0 +1,156 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseLintian(t *testing.T) {
+	const output = `E: min source: source-is-missing [src/min.js]
+W: min: binary-without-manpage usr/bin/min
+N:
+N:   Each binary in /usr/bin, /usr/sbin, /usr/games or /usr/X11R6/bin should
+N:   have a corresponding manual page entry.
+I: min-udeb udeb: udeb-postinst-calls-ldconfig
+P: min: no-upstream-changelog
+O: min: hardening-no-pie usr/bin/min
+W: min: spelling-error-in-description teh the
+`
+	got, err := parseLintian([]byte(output))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []lintianTag{
+		{Severity: "E", Package: "min", Type: "source", Tag: "source-is-missing", Info: "[src/min.js]"},
+		{Severity: "W", Package: "min", Tag: "binary-without-manpage", Info: "usr/bin/min"},
+		{Severity: "I", Package: "min-udeb", Type: "udeb", Tag: "udeb-postinst-calls-ldconfig"},
+		{Severity: "W", Package: "min", Tag: "spelling-error-in-description", Info: "teh the"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("parseLintian: got %+v, want %+v", got, want)
+	}
+	for idx, line := range []string{
+		"E: min source: source-is-missing [src/min.js]",
+		"W: min: binary-without-manpage usr/bin/min",
+		"I: min-udeb udeb: udeb-postinst-calls-ldconfig",
+	} {
+		if got := want[idx].String(); got != line {
+			t.Errorf("String(): got %q, want %q", got, line)
+		}
+	}
+}
+
+func TestNewLintianTags(t *testing.T) {
+	manpage := lintianTag{Severity: "W", Package: "min", Tag: "binary-without-manpage", Info: "usr/bin/min"}
+	otherManpage := lintianTag{Severity: "W", Package: "min", Tag: "binary-without-manpage", Info: "usr/bin/max"}
+	missing := lintianTag{Severity: "E", Package: "min", Type: "source", Tag: "source-is-missing", Info: "[src/min.js]"}
+
+	doc := lintianTag{Severity: "I", Package: "min", Tag: "package-contains-documentation-outside-usr-share-doc", Info: "[usr/lib/min-1.1/README]"}
+	previousDoc := lintianTag{Severity: "W", Package: "min", Tag: "package-contains-documentation-outside-usr-share-doc", Info: "[usr/lib/min-1.0/README]"}
+
+	got := newLintianTags([]lintianTag{manpage, otherManpage, missing, doc}, "1:1.1-1", []lintianTag{manpage, previousDoc}, "1:1.0-1")
+	if want := []lintianTag{otherManpage, missing}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("newLintianTags: got %+v, want %+v", got, want)
+	}
+
+	r := newLintianReport(got, "1.0")
+	if got, want := len(r.Errors), 1; got != want {
+		t.Fatalf("Unexpected number of errors: got %d, want %d", got, want)
+	}
+	if got, want := len(r.Warnings), 1; got != want {
+		t.Fatalf("Unexpected number of warnings: got %d, want %d", got, want)
+	}
+	want := []string{
+		"lintian: 1 error, 1 warning, 0 info tags not present in version 1.0",
+		"  E: min source: source-is-missing [src/min.js]",
+		"  W: min: binary-without-manpage usr/bin/max",
+	}
+	if got := r.Lines(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Lines: got %q, want %q", got, want)
+	}
+}
+
+func TestRunLintian(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "test-run-lintian-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	checkoutDir := filepath.Join(tempDir, "repo")
+	if err := os.MkdirAll(filepath.Join(checkoutDir, "debian"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	const entries = `min (1.1) unstable; urgency=medium
+
+  * Fix the build.
+
+ -- Chris Lamb <lamby@debian.org>  Tue, 02 Aug 2016 10:00:00 +0200
+
+min (1.0) unstable; urgency=medium
+
+  * Initial release.
+
+ -- Chris Lamb <lamby@debian.org>  Mon, 01 Aug 2016 10:00:00 +0200
+`
+	if err := ioutil.WriteFile(filepath.Join(checkoutDir, "debian", "changelog"), []byte(entries), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Divert apt-get with a shell script which creates the requested
+	// files, and lintian with a shell script which emits an additional
+	// warning for the new version.
+	aptGetDiversion := `#!/bin/sh
+case "$1" in
+source) touch min_1.0.dsc ;;
+download) [ "$2" = "min=1.0" ] && touch min_1.0_all.deb ;;
+esac
+`
+	lintianDiversion := `#!/bin/sh
+case "$*" in
+*min_1.1_amd64.changes)
+	echo "W: min: binary-without-manpage usr/bin/max"
+	echo "I: min: package-contains-documentation-outside-usr-share-doc [usr/lib/min-1.1/README]"
+	;;
+*min_1.0.dsc*min_1.0_all.deb*)
+	echo "I: min: package-contains-documentation-outside-usr-share-doc [usr/lib/min-1.0/README]"
+	;;
+*) exit 1 ;;
+esac
+echo "W: min: binary-without-manpage usr/bin/min"
+`
+	for name, contents := range map[string]string{
+		"apt-get": aptGetDiversion,
+		"lintian": lintianDiversion,
+	} {
+		if err := ioutil.WriteFile(filepath.Join(tempDir, name), []byte(contents), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	oldPath := os.Getenv("PATH")
+	defer os.Setenv("PATH", oldPath)
+	os.Setenv("PATH", tempDir+":"+oldPath)
+
+	result := buildResult{
+		Changes: filepath.Join(tempDir, "export", "min_1.1_amd64.changes"),
+		Dsc:     filepath.Join(tempDir, "export", "min_1.1.dsc"),
+		Debs: []string{
+			filepath.Join(tempDir, "export", "min_1.1_all.deb"),
+			filepath.Join(tempDir, "export", "min-doc_1.1_all.deb"),
+		},
+	}
+	r, err := runLintian(tempDir, checkoutDir, result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := lintianReport{
+		Warnings: []lintianTag{{Severity: "W", Package: "min", Tag: "binary-without-manpage", Info: "usr/bin/max"}},
+		Baseline: "1.0",
+	}
+	if !reflect.DeepEqual(r, want) {
+		t.Fatalf("runLintian: got %+v, want %+v", r, want)
+	}
+}
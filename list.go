@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Debian/mergebot/debbugs"
+)
+
+// pendingPatch is an open bug which is tagged patch, as displayed in
+// -list mode.
+type pendingPatch struct {
+	Bug     int
+	Subject string
+
+	// Series is the patch series which mergebot would merge by
+	// default, or nil if no patch could be found (see Err).
+	Series []patch
+	Err    error
+
+	// Applies is true if Series applies cleanly to the current
+	// packaging repository.
+	Applies bool
+}
+
+// pendingPatches returns all open bugs filed against sourcePackage
+// (or any of its binary packages) which are tagged patch.
+func pendingPatches(client *debbugs.Client, sourcePackage string) ([]pendingPatch, error) {
+	bugs, err := client.GetBugs("src", sourcePackage, "tag", "patch")
+	if err != nil {
+		return nil, err
+	}
+	if len(bugs) == 0 {
+		return nil, nil
+	}
+
+	statuses, err := client.GetStatus(bugs...)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []pendingPatch
+	for _, bug := range bugs {
+		status, ok := statuses[bug]
+		if !ok || status.Done != "" || status.Archived {
+			continue
+		}
+		pending := pendingPatch{
+			Bug:     bug,
+			Subject: status.Subject,
+		}
+		pending.Series, pending.Err = getPatchSeries(client, bug, 0, "")
+		result = append(result, pending)
+	}
+	return result, nil
+}
+
+// seriesApplies returns whether all patches of series apply cleanly
+// to the packaging repository in checkoutDir, using the strict
+// strategy of applyPatch (i.e. without fuzz), so that “yes” means that
+// mergebot will not need to fall back to a lenient strategy. The
+// repository is reset afterwards.
+func seriesApplies(tempDir, checkoutDir string, series []patch) (bool, error) {
+	defer func() {
+		if err := newCommand("git", "reset", "--hard").Run(); err != nil {
+			log.Printf("Could not reset repository: %v", err)
+		}
+		if err := newCommand("git", "clean", "-fdx").Run(); err != nil {
+			log.Printf("Could not clean repository: %v", err)
+		}
+	}()
+
+	for _, p := range series {
+		if err := ioutil.WriteFile(filepath.Join(tempDir, patchFileName), p.Data, 0600); err != nil {
+			return false, err
+		}
+		if err := applyStrict(checkoutDir, nil); err != nil {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// formatAge returns a human-readable representation of d, with a
+// resolution of hours for the first day and days afterwards.
+func formatAge(d time.Duration) string {
+	if d < 24*time.Hour {
+		return fmt.Sprintf("%dh", int(d.Hours()))
+	}
+	return fmt.Sprintf("%dd", int(d.Hours()/24))
+}
+
+func printPendingPatches(w io.Writer, pending []pendingPatch, now time.Time) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "Bug\tAge\tApplies\tSubject")
+	for _, p := range pending {
+		age, applies := "?", "no patch found"
+		if p.Err == nil {
+			latest := p.Series[len(p.Series)-1].Date
+			if !latest.IsZero() {
+				age = formatAge(now.Sub(latest))
+			}
+			applies = "no"
+			if p.Applies {
+				applies = "yes"
+			}
+		}
+		fmt.Fprintf(tw, "#%d\t%s\t%s\t%s\n", p.Bug, age, applies, p.Subject)
+	}
+	return tw.Flush()
+}
+
+// listPendingPatches prints all open bugs filed against sourcePackage
+// which are tagged patch, including the age of their latest patch and
+// whether it applies cleanly to the current packaging repository.
+func listPendingPatches(url, sourcePackage string, w io.Writer) (string, error) {
+	client := debbugs.NewClient(url)
+
+	tempDir, err := newTempDir()
+	if err != nil {
+		return tempDir, err
+	}
+
+	pending, err := pendingPatches(client, sourcePackage)
+	if err != nil {
+		return tempDir, err
+	}
+
+	checkoutDir, err := checkoutRepository(tempDir, sourcePackage)
+	if err != nil {
+		return tempDir, err
+	}
+
+	for idx, p := range pending {
+		if p.Err != nil {
+			log.Printf("Bug #%d: %v", p.Bug, p.Err)
+			continue
+		}
+		pending[idx].Applies, err = seriesApplies(tempDir, checkoutDir, p.Series)
+		if err != nil {
+			return tempDir, err
+		}
+	}
+
+	return tempDir, printPendingPatches(w, pending, time.Now())
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Debian/mergebot/debbugs"
+	"github.com/Debian/mergebot/debbugs/debbugstest"
+)
+
+func TestPendingPatches(t *testing.T) {
+	srv := debbugstest.NewServer()
+	defer srv.Close()
+	srv.Statuses[831331] = debbugs.Status{
+		Package: "wit",
+		Source:  "wit",
+		Subject: "wit: please make the build reproducible",
+		Tags:    "patch",
+	}
+	srv.Statuses[815710] = debbugs.Status{
+		Package: "wit",
+		Source:  "wit",
+		Subject: "wit: please enable zlib support",
+		Tags:    "patch",
+		Done:    "Michael Stapelberg <stapelberg@debian.org>",
+	}
+	srv.Statuses[831332] = debbugs.Status{
+		Package: "wit",
+		Source:  "wit",
+		Subject: "wit: crashes on startup",
+	}
+	srv.Recordings["get_bug_log"] = goldenSoapPath
+
+	pending, err := pendingPatches(debbugs.NewClient(srv.URL), "wit")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got, want := len(pending), 1; got != want {
+		t.Fatalf("Unexpected number of pending patches: got %d, want %d (%+v)", got, want, pending)
+	}
+	if got, want := pending[0].Bug, 831331; got != want {
+		t.Fatalf("Incorrect bug: got %d, want %d", got, want)
+	}
+	if pending[0].Err != nil {
+		t.Fatalf("Unexpected error for bug #%d: %v", pending[0].Bug, pending[0].Err)
+	}
+
+	pending[0].Applies = true
+	now := time.Date(2016, time.July, 17, 18, 19, 29, 0, time.FixedZone("", 2*60*60))
+	var buf bytes.Buffer
+	if err := printPendingPatches(&buf, pending, now); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), `Bug      Age  Applies  Subject
+#831331  3d   yes      wit: please make the build reproducible
+`; got != want {
+		t.Fatalf("Unexpected output: got %q, want %q", got, want)
+	}
+}
+
+func TestFormatAge(t *testing.T) {
+	for _, tt := range []struct {
+		d    time.Duration
+		want string
+	}{
+		{90 * time.Minute, "1h"},
+		{25 * time.Hour, "1d"},
+		{400 * 24 * time.Hour, "400d"},
+	} {
+		if got := formatAge(tt.d); got != tt.want {
+			t.Errorf("formatAge(%v): got %q, want %q", tt.d, got, tt.want)
+		}
+	}
+}
+
+func TestSeriesApplies(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "test-series-applies-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	repo := setupQuiltRepo(t, tempDir)
+	defer useRepo(repo)()
+	readme := filepath.Join(repo, "README")
+	if err := ioutil.WriteFile(readme, []byte("a\nb\nc\nd\ne\nf\ng\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	gitIn(t, repo, "commit", "-a", "-m", "Extend README")
+
+	exact := patch{Data: []byte("--- a/README\n+++ b/README\n@@ -2,5 +2,5 @@\n b\n c\n-d\n+D\n e\n f\n")}
+	// The first line of context does not match, so the patch only
+	// applies with fuzz.
+	fuzzy := patch{Data: []byte("--- a/README\n+++ b/README\n@@ -2,5 +2,5 @@\n x\n c\n-d\n+D\n e\n f\n")}
+	for _, tt := range []struct {
+		series []patch
+		want   bool
+	}{
+		{[]patch{exact}, true},
+		{[]patch{fuzzy}, false},
+		{[]patch{exact, exact}, false},
+	} {
+		got, err := seriesApplies(tempDir, repo, tt.series)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("seriesApplies(%d patches): got %v, want %v", len(tt.series), got, tt.want)
+		}
+	}
+
+	contents, err := ioutil.ReadFile(readme)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(contents), "a\nb\nc\nd\ne\nf\ng\n"; got != want {
+		t.Fatalf("Repository was not reset: got README %q, want %q", got, want)
+	}
+}
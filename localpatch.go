@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+
+	"github.com/Debian/mergebot/debbugs"
+)
+
+var (
+	mbox = flag.String("mbox",
+		"",
+		"Path to an mbox file (e.g. git format-patch --stdout output) containing the patches to merge instead of downloading them from -bug.")
+
+	eml = flag.String("eml",
+		"",
+		"Path to a single mail (.eml file) containing the patches to merge instead of downloading them from -bug.")
+
+	patchFile = flag.String("patch_file",
+		"",
+		"Path to a patch file to merge instead of downloading it from -bug.")
+
+	author = flag.String("author",
+		"",
+		"Author (in “Name <email>” form) to use for patches which do not specify their author, e.g. -patch_file.")
+)
+
+var (
+	// mboxSeparatorRe matches the “From ” line which separates messages
+	// in an mbox file, consisting of the envelope sender and an asctime
+	// date, e.g. “From alice@example.net Thu Jul  4 17:20:12 2016”. Only
+	// such lines are separators, as not all mbox writers escape other
+	// lines starting with “From ”.
+	mboxSeparatorRe = regexp.MustCompile(`^From \S+ +[A-Z][a-z]{2} [A-Z][a-z]{2} +\d{1,2} \d{2}:\d{2}(?::\d{2})?(?: \S+)? \d{4}$`)
+
+	// mboxFromRe matches lines in message bodies which were escaped
+	// because they start with “From ” (see mboxrd in mbox(5)).
+	mboxFromRe = regexp.MustCompile(`^>+From `)
+)
+
+// localInput returns the path of the local patch input specified via
+// -mbox, -eml or -patch_file, or "" if patches should be downloaded
+// from the BTS.
+func localInput() string {
+	for _, path := range []string{*mbox, *eml, *patchFile} {
+		if path != "" {
+			return path
+		}
+	}
+	return ""
+}
+
+// splitMbox returns the messages contained in the mbox read from r,
+// each including its “From ” separator line. A single message without
+// separator line is returned as is.
+func splitMbox(r io.Reader) ([][]byte, error) {
+	var messages [][]byte
+	var current []byte
+	previousEmpty := true
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if current == nil || previousEmpty && mboxSeparatorRe.MatchString(line) {
+			// The empty line before the “From ” line is kept, as git
+			// format-patch --stdout ends each patch with one, too.
+			if current != nil {
+				messages = append(messages, current)
+			}
+			current = []byte{}
+		}
+		previousEmpty = line == ""
+		if mboxFromRe.MatchString(line) {
+			line = line[1:]
+		}
+		current = append(current, line+"\n"...)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if current != nil {
+		messages = append(messages, current)
+	}
+	return messages, nil
+}
+
+// patchesFromMbox returns all patches of all messages in the mbox read
+// from r, newest (i.e. last) message first. Messages are numbered
+// starting with 1, in the same way as messages in a bug log.
+func patchesFromMbox(r io.Reader) ([]patch, error) {
+	messages, err := splitMbox(r)
+	if err != nil {
+		return nil, err
+	}
+	var candidates []patch
+	for idx := len(messages) - 1; idx >= 0; idx-- {
+		patches, err := patchesFromRawMessage(idx+1, messages[idx])
+		if err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, patches...)
+	}
+	return candidates, nil
+}
+
+// patchesFromRawMessage returns all patches of the mail raw (which may
+// start with an mbox “From ” line), numbered msgNum.
+func patchesFromRawMessage(msgNum int, raw []byte) ([]patch, error) {
+	message := raw
+	if bytes.HasPrefix(message, []byte("From ")) {
+		if nl := bytes.IndexByte(message, '\n'); nl > -1 {
+			message = message[nl+1:]
+		}
+	}
+	item := debbugs.BugLogItem{MsgNum: msgNum}
+	if parts := bytes.SplitN(message, []byte("\n\n"), 2); len(parts) == 2 {
+		item.Header, item.Body = string(parts[0]), string(parts[1])
+	} else {
+		item.Header = string(message)
+	}
+	patches, err := patchesFromMessage(item)
+	if err != nil {
+		return nil, fmt.Errorf("message #%d: %v", item.MsgNum, err)
+	}
+	// A message generated by git format-patch is the patch itself, so
+	// pass it to git am as a whole to retain the commit message.
+	if len(patches) == 1 && patches[0].Filename == "" {
+		if formatPatch := (patch{Data: raw}); formatPatch.isGitFormatPatch() {
+			patches[0].Data = raw
+		}
+	}
+	return patches, nil
+}
+
+// formatPatchSeries returns candidates (as returned by patchesFromMbox)
+// as one series, ordered by series number and mbox order, if all of
+// them were generated by git format-patch.
+func formatPatchSeries(candidates []patch) ([]patch, bool) {
+	for _, p := range candidates {
+		if !p.isGitFormatPatch() {
+			return nil, false
+		}
+	}
+	// Restore mbox order for patches without series number.
+	series := make([]patch, len(candidates))
+	for idx, p := range candidates {
+		series[len(candidates)-1-idx] = p
+	}
+	sort.Stable(bySeriesNumber(series))
+	return series, true
+}
+
+// patchFromFile returns the patch contained in the specified file.
+func patchFromFile(path string) (patch, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return patch{}, err
+	}
+	filename := filepath.Base(path)
+	data, err = decompress(filename, "", data)
+	if err != nil {
+		return patch{}, fmt.Errorf("%q: %v", path, err)
+	}
+	p := patch{
+		Data:      data,
+		Filename:  filename,
+		MediaType: "text/x-diff",
+	}
+	if !p.looksLikePatch() {
+		return patch{}, fmt.Errorf("%q does not look like a patch", path)
+	}
+	return p, nil
+}
+
+// getLocalPatchSeries returns the patch series from the local input
+// specified via -mbox, -eml or -patch_file. Messages and attachments
+// are selected as described in getPatchSeries, except that an mbox
+// consisting of git format-patch mails is merged as one series.
+func getLocalPatchSeries(msgNum int, attachment string) ([]patch, error) {
+	if *patchFile != "" {
+		p, err := patchFromFile(*patchFile)
+		if err != nil {
+			return nil, err
+		}
+		return []patch{p}, nil
+	}
+
+	path := localInput()
+	var candidates []patch
+	if path == *eml {
+		// A single mail is not split like an mbox, so that lines
+		// starting with “From ” remain part of its body.
+		raw, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if candidates, err = patchesFromRawMessage(1, raw); err != nil {
+			return nil, fmt.Errorf("%q: %v", path, err)
+		}
+	} else {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		if candidates, err = patchesFromMbox(f); err != nil {
+			return nil, fmt.Errorf("%q: %v", path, err)
+		}
+	}
+
+	if msgNum == 0 && attachment == "" && len(candidates) > 1 {
+		if series, ok := formatPatchSeries(candidates); ok {
+			return series, nil
+		}
+	}
+
+	series, err := selectPatchSeries(candidates, fmt.Sprintf("%q", path), msgNum, attachment)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range series {
+		log.Printf("Using attachment %q (%s) from message #%d of %q, signature: %v", p.Filename, p.MediaType, p.MsgNum, path, p.Trust)
+	}
+	return series, nil
+}
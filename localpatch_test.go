@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSplitMbox(t *testing.T) {
+	const input = `From alice@example.net Thu Jul 14 17:20:12 2016
+From: Alice <alice@example.net>
+Subject: first
+
+Hello,
+From the start, this is not a separator.
+>From here on, neither is this.
+
+From what I can tell, an unescaped paragraph is not a separator either.
+
+From bob@example.net Thu Jul 14 17:21:12 2016
+From: Bob <bob@example.net>
+Subject: second
+
+Body.
+`
+	messages, err := splitMbox(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(messages), 2; got != want {
+		t.Fatalf("Unexpected number of messages: got %d, want %d", got, want)
+	}
+	if got, want := string(messages[0]), `From alice@example.net Thu Jul 14 17:20:12 2016
+From: Alice <alice@example.net>
+Subject: first
+
+Hello,
+From the start, this is not a separator.
+From here on, neither is this.
+
+From what I can tell, an unescaped paragraph is not a separator either.
+
+`; got != want {
+		t.Fatalf("Incorrect first message: got %q, want %q", got, want)
+	}
+	if !strings.HasPrefix(string(messages[1]), "From bob@example.net ") {
+		t.Fatalf("Second message does not start with its separator line: %q", messages[1])
+	}
+}
+
+func TestLocalPatchSeriesMbox(t *testing.T) {
+	oldMbox := *mbox
+	defer func() { *mbox = oldMbox }()
+	*mbox = "testdata/series.mbox"
+
+	series, err := getLocalPatchSeries(0, "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	goldenPaths := []string{
+		"testdata/series/0001-Declare-Standards-Version-3.9.7.patch",
+		"testdata/series/0002-Add-Homepage-field.patch",
+	}
+	if got, want := len(series), len(goldenPaths); got != want {
+		t.Fatalf("Unexpected number of patches: got %d, want %d", got, want)
+	}
+	for idx, goldenPath := range goldenPaths {
+		goldenPatch, err := ioutil.ReadFile(goldenPath)
+		if err != nil {
+			t.Fatalf("Could not read golden patch data from %q for comparison: %v", goldenPath, err)
+		}
+		if !bytes.Equal(series[idx].Data, goldenPatch) {
+			t.Fatalf("Patch %d does not match %q: got %q", idx+1, goldenPath, series[idx].Data)
+		}
+		if !series[idx].isGitFormatPatch() {
+			t.Fatalf("Patch %d is not recognized as git format-patch output", idx+1)
+		}
+	}
+	if got, want := series[0].Author, "Chris Lamb <lamby@debian.org>"; got != want {
+		t.Fatalf("Incorrect patch author: got %q, want %q", got, want)
+	}
+
+	// Selecting a message works the same as for bug logs.
+	series, err = getLocalPatchSeries(2, "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got, want := len(series), 1; got != want {
+		t.Fatalf("Unexpected number of patches: got %d, want %d", got, want)
+	}
+	if got, want := series[0].seriesNumber(), 2; got != want {
+		t.Fatalf("Incorrect patch selected: got series number %d, want %d", got, want)
+	}
+}
+
+func TestLocalPatchSeriesEml(t *testing.T) {
+	oldEml := *eml
+	defer func() { *eml = oldEml }()
+	*eml = "testdata/831331.eml"
+
+	series, err := getLocalPatchSeries(0, "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got, want := len(series), 1; got != want {
+		t.Fatalf("Unexpected number of patches: got %d, want %d", got, want)
+	}
+	p := series[0]
+	if got, want := p.Author, "Chris Lamb <lamby@debian.org>"; got != want {
+		t.Fatalf("Incorrect patch author: got %q, want %q", got, want)
+	}
+	if got, want := p.Subject, "wit: please make the build reproducible"; got != want {
+		t.Fatalf("Incorrect patch subject: got %q, want %q", got, want)
+	}
+	if got, want := p.Filename, "wit.diff.txt"; got != want {
+		t.Fatalf("Incorrect attachment: got %q, want %q", got, want)
+	}
+	goldenPatch, err := ioutil.ReadFile(goldenPatchPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(p.Data, goldenPatch) {
+		t.Fatalf("Patch data parsed from %q does not match %q", *eml, goldenPatchPath)
+	}
+}
+
+func TestLocalPatchSeriesEmlFromLine(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "test-local-patch-eml-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	const diff = "--- a/README\n+++ b/README\n@@ -1 +1 @@\n-min\n+max\n"
+	const message = "From: Chris Lamb <lamby@debian.org>\nSubject: wit: FTBFS\n\nHi,\n\nFrom what I can tell, this fixes the build:\n\n" + diff
+	oldEml := *eml
+	defer func() { *eml = oldEml }()
+	*eml = filepath.Join(tempDir, "message.eml")
+	if err := ioutil.WriteFile(*eml, []byte(message), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	series, err := getLocalPatchSeries(0, "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got, want := len(series), 1; got != want {
+		t.Fatalf("Unexpected number of patches: got %d, want %d", got, want)
+	}
+	p := series[0]
+	if got, want := p.Subject, "wit: FTBFS"; got != want {
+		t.Fatalf("Incorrect patch subject: got %q, want %q", got, want)
+	}
+	if got, want := string(p.Data), diff; got != want {
+		t.Fatalf("Incorrect patch data: got %q, want %q", got, want)
+	}
+}
+
+func TestLocalPatchSeriesPatchFile(t *testing.T) {
+	oldPatchFile := *patchFile
+	defer func() { *patchFile = oldPatchFile }()
+	*patchFile = goldenPatchPath
+
+	series, err := getLocalPatchSeries(0, "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got, want := len(series), 1; got != want {
+		t.Fatalf("Unexpected number of patches: got %d, want %d", got, want)
+	}
+	if got, want := series[0].Filename, "831331.patch"; got != want {
+		t.Fatalf("Incorrect file name: got %q, want %q", got, want)
+	}
+	if got, want := series[0].Author, ""; got != want {
+		t.Fatalf("Unexpected patch author: got %q, want %q", got, want)
+	}
+
+	*patchFile = "testdata/831331.eml"
+	if _, err := getLocalPatchSeries(0, ""); err == nil {
+		t.Fatalf("getLocalPatchSeries unexpectedly accepted a -patch_file which is not a patch")
+	}
+}
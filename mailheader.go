@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/mail"
+	"strings"
+)
+
+// charsetReader converts input from charset to UTF-8. mime.WordDecoder
+// handles UTF-8, ISO-8859-1 and US-ASCII itself, all other charsets
+// are converted using iconv(1).
+func charsetReader(charset string, input io.Reader) (io.Reader, error) {
+	cmd := newCommand("iconv", "--from-code", charset, "--to-code", "UTF-8")
+	cmd.Stdin = input
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("Could not convert from charset %q: %v", charset, err)
+	}
+	return bytes.NewReader(output), nil
+}
+
+var wordDecoder = &mime.WordDecoder{CharsetReader: charsetReader}
+
+// decodeHeader decodes RFC 2047 encoded-words (e.g.
+// “=?UTF-8?Q?Don=E2=80=99t?=”) in value. In case value cannot be
+// decoded, it is returned unmodified.
+func decodeHeader(value string) string {
+	decoded, err := wordDecoder.DecodeHeader(value)
+	if err != nil {
+		log.Printf("Could not decode header value %q: %v", value, err)
+		return value
+	}
+	return decoded
+}
+
+// formatAuthor returns addr in the “Name <email>” form which git
+// expects for --author. In case addr does not specify a name, the
+// local part of the address is used.
+func formatAuthor(addr *mail.Address) string {
+	name := addr.Name
+	if name == "" {
+		name = addr.Address
+		if idx := strings.Index(name, "@"); idx > -1 {
+			name = name[:idx]
+		}
+	}
+	// git strips angle brackets and newlines from names, make that
+	// explicit.
+	name = strings.TrimSpace(strings.Map(func(r rune) rune {
+		if r == '<' || r == '>' || r == '\n' {
+			return -1
+		}
+		return r
+	}, name))
+	return fmt.Sprintf("%s <%s>", name, addr.Address)
+}
+
+// authorFromHeader returns the author of the message with header h,
+// suitable for git --author. The author is taken from the From header,
+// falling back to the envelope sender when From is missing or
+// malformed.
+func authorFromHeader(h mail.Header) (string, error) {
+	parser := mail.AddressParser{WordDecoder: wordDecoder}
+	for _, key := range []string{"From", "Return-Path", "X-Envelope-From"} {
+		value := h.Get(key)
+		if value == "" {
+			continue
+		}
+		addr, err := parser.Parse(value)
+		if err != nil || addr.Address == "" {
+			log.Printf("Skipping malformed %s header %q (%v)", key, value, err)
+			continue
+		}
+		return formatAuthor(addr), nil
+	}
+	return "", fmt.Errorf("No valid author address found in From, Return-Path or X-Envelope-From header")
+}
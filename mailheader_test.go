@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/mail"
+	"testing"
+)
+
+func TestDecodeHeader(t *testing.T) {
+	for _, tt := range []struct {
+		value string
+		want  string
+	}{
+		{"wit: please make the build reproducible", "wit: please make the build reproducible"},
+		{"=?UTF-8?Q?wit:_Don=E2=80=99t_link_against_libdl?=", "wit: Don’t link against libdl"},
+		{"=?UTF-8?B?d2l0OiBEb27igJl0IGxpbmsgYWdhaW5zdCBsaWJkbA==?=", "wit: Don’t link against libdl"},
+		{"=?ISO-8859-15?Q?Gr=FC=DFe_=A4?=", "Grüße €"},
+		{"=?KOI8-R?B?8NLJ18XU?=", "Привет"},
+	} {
+		if got := decodeHeader(tt.value); got != tt.want {
+			t.Errorf("decodeHeader(%q): got %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestAuthorFromHeader(t *testing.T) {
+	for _, tt := range []struct {
+		header mail.Header
+		want   string
+	}{
+		{
+			header: mail.Header{"From": []string{"Chris Lamb <lamby@debian.org>"}},
+			want:   "Chris Lamb <lamby@debian.org>",
+		},
+		{
+			header: mail.Header{"From": []string{"=?UTF-8?Q?J=C3=B6rg_M=C3=BCller?= <joerg@example.org>"}},
+			want:   "Jörg Müller <joerg@example.org>",
+		},
+		{
+			header: mail.Header{"From": []string{"=?ISO-8859-15?Q?J=F6rg_M=FCller?= <joerg@example.org>"}},
+			want:   "Jörg Müller <joerg@example.org>",
+		},
+		{
+			header: mail.Header{"From": []string{"lamby@debian.org"}},
+			want:   "lamby <lamby@debian.org>",
+		},
+		{
+			// Malformed From header: fall back to the envelope sender.
+			header: mail.Header{
+				"From":        []string{"Chris Lamb lamby at debian.org"},
+				"Return-Path": []string{"<lamby@debian.org>"},
+			},
+			want: "lamby <lamby@debian.org>",
+		},
+		{
+			// Missing From header.
+			header: mail.Header{"Return-Path": []string{"<lamby@debian.org>"}},
+			want:   "lamby <lamby@debian.org>",
+		},
+	} {
+		got, err := authorFromHeader(tt.header)
+		if err != nil {
+			t.Errorf("authorFromHeader(%v): unexpected error: %v", tt.header, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("authorFromHeader(%v): got %q, want %q", tt.header, got, tt.want)
+		}
+	}
+
+	if _, err := authorFromHeader(mail.Header{"Return-Path": []string{"<>"}}); err == nil {
+		t.Errorf("authorFromHeader unexpectedly succeeded without any valid address")
+	}
+}
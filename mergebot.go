@@ -1,24 +1,29 @@
 package main
 
 import (
-	"crypto/sha256"
+	"bytes"
 	"flag"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
 	"strings"
 
+	"github.com/Debian/mergebot/debbugs"
+	"github.com/Debian/mergebot/gitlab"
 	"github.com/Debian/mergebot/loggedexec"
 )
 
 var (
-	sourcePackage = flag.String("source_package", "", "Debian source package against which the bug specified in -bug was filed.")
+	sourcePackage = flag.String("source_package", "", "Debian source package against which the bug specified in -bug was filed. Inferred from -bug if empty.")
 	bug           = flag.String("bug", "", "Debian bug number containing the patch to merge (e.g. 831331 or #831331)")
-
-	filterChangelogMode = flag.Bool("filter_changelog", false, "Not for interactive usage, will be removed! Run in filter changelog mode to work around a gbp dch issue.")
+	msg           = flag.Int("msg", 0, "Number of the message within -bug (or -mbox) whose patch should be merged (e.g. 5 for https://bugs.debian.org/831331#5). Defaults to the most recent message with a patch.")
+	attachment    = flag.String("attachment", "", "File name of the attachment to merge (e.g. wit.diff.txt). By default, all patch attachments of the message are merged as a series.")
+	list          = flag.Bool("list", false, "List all open bugs of -source_package which are tagged patch, including whether their latest patch applies cleanly, instead of merging a patch.")
 )
 
 // newCommand will be overwritten by mergeAndBuild() once the
@@ -29,6 +34,22 @@ const (
 	patchFileName = "latest.patch"
 )
 
+// lookupChoice returns the value called name in choices, which maps
+// the valid values of flagName to what they select (e.g. builders). In
+// case name is unknown, the error lists all valid values.
+func lookupChoice(flagName string, choices interface{}, name string) (interface{}, error) {
+	m := reflect.ValueOf(choices)
+	if v := m.MapIndex(reflect.ValueOf(name)); v.IsValid() {
+		return v.Interface(), nil
+	}
+	names := make([]string, 0, m.Len())
+	for _, key := range m.MapKeys() {
+		names = append(names, key.String())
+	}
+	sort.Strings(names)
+	return nil, fmt.Errorf("Unknown %s %q, expected one of %s", flagName, name, strings.Join(names, ", "))
+}
+
 func repositoryFor(sourcePackage string) (string, string, error) {
 	cmd := newCommand("debcheckout", "--print", sourcePackage)
 	output, err := cmd.Output()
@@ -86,9 +107,57 @@ func gitCheckout(dst, src string) error {
 	return nil
 }
 
-// TODO: use git am for git format patches to respect the user’s commit metadata
-func applyPatch() error {
-	return newCommand("patch", "-p1", "-i", filepath.Join("..", patchFileName)).Run()
+// gitAm applies a patch generated by git format-patch, which retains
+// the contributor’s commit message, author date and trailers. In case
+// the commit message does not close the bug already, a Closes trailer
+// is added so that releaseChangelog picks it up. An empty bug means
+// the patch was not submitted via the BTS, so there is nothing to
+// close.
+func gitAm(bug string) error {
+	if err := newCommand("git", "am", filepath.Join("..", patchFileName)).Run(); err != nil {
+		// Leave a clean tree behind instead of an am session in
+		// progress, so that the patch can be applied otherwise.
+		if abortErr := newCommand("git", "am", "--abort").Run(); abortErr != nil {
+			log.Printf("Could not abort git am: %v", abortErr)
+		}
+		return err
+	}
+	if bug == "" {
+		return nil
+	}
+	return appendTrailers(fmt.Sprintf("Closes: #%s", bug))
+}
+
+// appendTrailers amends the most recent commit, appending those of the
+// newline-separated trailers which its message does not contain yet.
+func appendTrailers(trailers string) error {
+	message, err := newCommand("git", "log", "-1", "--format=%B").Output()
+	if err != nil {
+		return err
+	}
+	var missing []string
+	for _, trailer := range strings.Split(trailers, "\n") {
+		if !strings.Contains(string(message), trailer) {
+			missing = append(missing, trailer)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	// git interpret-trailers adds to an existing trailer block instead
+	// of starting a new paragraph.
+	args := []string{"interpret-trailers"}
+	for _, trailer := range missing {
+		args = append(args, "--trailer", trailer)
+	}
+	cmd := newCommand("git", args...)
+	cmd.Stdin = bytes.NewReader(message)
+	amended, err := cmd.Output()
+	if err != nil {
+		return err
+	}
+	return newCommand("git", "commit", "--amend",
+		"--message", strings.TrimSpace(string(amended))).Run()
 }
 
 func gitCommit(author, message string) error {
@@ -101,51 +170,66 @@ func gitCommit(author, message string) error {
 		"--message", message).Run()
 }
 
-func sha256of(path string) (string, error) {
-	h := sha256.New()
+// mergePatch applies the patch stored in patchFileName to the packaging
+// repository in checkoutDir and commits the result. git format-patch
+// submissions bring their own commit message (see gitAm for bug), all
+// other patches are committed using message. In case git am fails,
+// the patch is applied using the strategies of applyPatch instead.
+func mergePatch(checkoutDir string, p patch, message, bug string) error {
+	if p.isGitFormatPatch() {
+		err := gitAm(bug)
+		if err == nil {
+			return nil
+		}
+		log.Printf("git am failed, applying the patch using patch(1) instead: %v", err)
+	}
 
-	f, err := os.Open(path)
+	author, err := patchAuthor(p)
 	if err != nil {
-		return "", err
+		return err
 	}
-	defer f.Close()
 
-	if _, err := io.Copy(h, f); err != nil {
-		return "", err
+	if err := applyPatch(checkoutDir, p.BaseVersions); err != nil {
+		return err
 	}
 
-	return fmt.Sprintf("%.16x", h.Sum(nil)), nil
+	return gitCommit(author, message)
 }
 
-// TODO: if gbp dch returns with “Version %s not found”, that’s fine, as the changelog is already up to date. Can we detect this case, or change our gbp dch invocation to not complain?
-func releaseChangelog() error {
-	cmd := newCommand("gbp", "dch", "--release", "--git-author", "--commit")
-	// See the comment on filterChangelog() for details:
-	self, err := filepath.Abs(os.Args[0])
-	if err != nil {
-		return err
+// patchAuthor returns the author of p, falling back to -author.
+func patchAuthor(p patch) (string, error) {
+	if p.Author != "" {
+		return p.Author, nil
+	}
+	if *author != "" {
+		return *author, nil
 	}
-	cmd.Env = append(cmd.Env, []string{
-		// Set VISUAL because gbp dch has no flag to specify the editor.
-		// Ideally we’d set this to /bin/true, but we need to filter the changelog because “gbp dch” generates an empty entry.
-		fmt.Sprintf("VISUAL=%s -filter_changelog", self),
-	}...)
-	return cmd.Run()
+	if p.MsgNum == 0 {
+		return "", fmt.Errorf("Could not determine the author of %q, please specify -author", p.Filename)
+	}
+	return "", fmt.Errorf("Could not determine the author of message #%d, please specify -author", p.MsgNum)
 }
 
-func buildPackage() error {
-	return newCommand("gbp", "buildpackage",
-		// Tag debian/%(version)s after building successfully.
-		"--git-tag",
-		// Build in a separate directory to avoid modifying the git checkout.
-		"--git-export-dir=../export",
-		"--git-builder=sbuild -v -As --dist=unstable").Run()
+// commitMessage returns the commit message for patch number idx
+// (starting at 0) of a series with total patches. The message closes
+// bug, unless bug is empty (e.g. for -patch_file).
+func commitMessage(p patch, idx, total int, bug string) string {
+	what := fmt.Sprintf("Fix for “%s”", p.Subject)
+	if p.Subject == "" {
+		what = fmt.Sprintf("Apply %s", p.Filename)
+	}
+	if total > 1 {
+		what = fmt.Sprintf("%s, part %d/%d", what, idx+1, total)
+	}
+	if bug == "" {
+		return what
+	}
+	return fmt.Sprintf("%s (Closes: #%s)", what, bug)
 }
 
-// mergeAndBuild downloads the most recent patch in the specified bug
-// from the BTS, checks out the package’s packaging repository, merges
-// the patch and builds the package.
-func mergeAndBuild(url string) (string, error) {
+// newTempDir creates a temporary directory and overwrites newCommand
+// so that all commands log into it.
+func newTempDir() (string, error) {
 	tempDir, err := ioutil.TempDir("", "mergebot-")
 	if err != nil {
 		return tempDir, err
@@ -162,27 +246,23 @@ func mergeAndBuild(url string) (string, error) {
 		}
 		return cmd
 	}
+	return tempDir, nil
+}
 
-	patch, err := getMostRecentPatch(url, *bug)
-	if err != nil {
-		return tempDir, err
-	}
-
-	if err := ioutil.WriteFile(filepath.Join(tempDir, patchFileName), patch.Data, 0600); err != nil {
-		return tempDir, err
-	}
-
-	scm, url, err := repositoryFor(*sourcePackage)
+// checkoutRepository checks out the packaging repository of
+// sourcePackage into tempDir/repo and makes every command run in that
+// directory by default from then on.
+func checkoutRepository(tempDir, sourcePackage string) (string, error) {
+	scm, url, err := repositoryFor(sourcePackage)
 	if err != nil {
-		return tempDir, err
+		return "", err
 	}
 	if scm != "git" {
-		return tempDir, fmt.Errorf("mergebot only supports git currently, but %q is using the SCM %q", url, scm)
+		return "", fmt.Errorf("mergebot only supports git currently, but %q is using the SCM %q", url, scm)
 	}
 
 	checkoutDir := filepath.Join(tempDir, "repo")
 
-	// Make every command run in checkoutDir by default from now on.
 	previousNewCommand := newCommand
 	newCommand = func(name string, arg ...string) *loggedexec.LoggedCmd {
 		cmd := previousNewCommand(name, arg...)
@@ -190,75 +270,322 @@ func mergeAndBuild(url string) (string, error) {
 		return cmd
 	}
 
-	if err := gitCheckout(checkoutDir, url); err != nil {
-		return tempDir, err
+	return checkoutDir, gitCheckout(checkoutDir, url)
+}
+
+// summary describes the outcome of a successful mergeAndBuild run.
+type summary struct {
+	// Suite is the suite for which the package was built.
+	Suite string
+
+	Build buildResult
+
+	// Lintian is nil when -lintian=false.
+	Lintian *lintianReport
+
+	// Autopkgtest is nil when -autopkgtest=false or when the package
+	// has no autopkgtests.
+	Autopkgtest *autopkgtestReport
+}
+
+// buildConfig is what the flags select for releasing, building and
+// testing the package, see checkFlags.
+type buildConfig struct {
+	Upload  uploadType
+	Suite   string
+	Builder builder
+
+	// Virt is nil when -autopkgtest=false.
+	Virt autopkgtestVirt
+}
+
+// checkFlags validates the flags and verifies that the selected
+// builder, testbed and tools are available, so that mergebot fails
+// before cloning and merging.
+func checkFlags() (buildConfig, error) {
+	var inputs int
+	for _, input := range []string{*mbox, *eml, *patchFile, *mergeRequest} {
+		if input != "" {
+			inputs++
+		}
+	}
+	if inputs > 1 {
+		return buildConfig{}, fmt.Errorf("At most one of -mbox, -eml, -patch_file and -merge_request can be specified")
 	}
 
-	// TODO: edge case: the user might supply a patch which touches changelog but doesn’t include Closes: #bugnumber. In that case, we should modify the changelog accordingly (e.g. using debchange --closes?)
+	var cfg buildConfig
+	var err error
+	if cfg.Upload, err = lookupUploadType(*uploadTypeName); err != nil {
+		return buildConfig{}, err
+	}
+	if cfg.Suite, err = resolveTargetSuite(cfg.Upload); err != nil {
+		return buildConfig{}, err
+	}
+	if err := checkTargetSuite(cfg.Suite, cfg.Upload); err != nil {
+		return buildConfig{}, err
+	}
+	if cfg.Builder, err = lookupBuilder(*builderName); err != nil {
+		return buildConfig{}, err
+	}
+	if err := cfg.Builder.Check(cfg.Suite); err != nil {
+		return buildConfig{}, err
+	}
+
+	if *lintianEnabled {
+		if err := checkLintian(); err != nil {
+			return buildConfig{}, err
+		}
+	}
 
-	changelogPath := filepath.Join(checkoutDir, "debian", "changelog")
-	oldChangelogSum, err := sha256of(changelogPath)
+	if *autopkgtestEnabled {
+		if cfg.Virt, err = lookupAutopkgtestVirt(*autopkgtestVirtName); err != nil {
+			return buildConfig{}, err
+		}
+		if err := cfg.Virt.Check(cfg.Suite); err != nil {
+			return buildConfig{}, err
+		}
+	}
+	return cfg, nil
+}
+
+// mergeAndBuild downloads the patches selected by -msg and -attachment
+// (by default, all patches of the most recent message with patches)
+// in the specified bug from the BTS, checks out the package’s
+// packaging repository, merges the patches and builds the package.
+// When -mbox, -eml or -patch_file is specified, the patches are read
+// from that file instead, and -bug is optional. The same applies to
+// -merge_request, whose commits are fetched into the packaging
+// repository.
+func mergeAndBuild(url string) (string, summary, error) {
+	local := localInput()
+	var bugNum int
+	if *bug != "" || (local == "" && *mergeRequest == "") {
+		var err error
+		bugNum, err = strconv.Atoi(*bug)
+		if err != nil {
+			return "", summary{}, fmt.Errorf("Invalid -bug %q: %v", *bug, err)
+		}
+	}
+	client := debbugs.NewClient(url)
+
+	cfg, err := checkFlags()
 	if err != nil {
-		return tempDir, err
+		return "", summary{}, err
 	}
+	suite, b, virt := cfg.Suite, cfg.Builder, cfg.Virt
+	log.Printf("will build for suite %q using %s", suite, *builderName)
 
-	if err := applyPatch(); err != nil {
-		return tempDir, err
+	tempDir, err := newTempDir()
+	if err != nil {
+		return tempDir, summary{}, err
 	}
 
-	patchCommitMessage := fmt.Sprintf("Fix for “%s” (Closes: #%s)", patch.Subject, *bug)
-	if err := gitCommit(patch.Author, patchCommitMessage); err != nil {
-		return tempDir, err
+	var mr gitlab.MergeRequest
+	var project gitlab.Project
+	if *mergeRequest != "" {
+		mr, project, err = getMergeRequest(*mergeRequest)
+		if err != nil {
+			return tempDir, summary{}, err
+		}
+	}
+
+	var baseVersions []string
+	if bugNum != 0 {
+		*sourcePackage, err = resolveSourcePackage(client, bugNum, *sourcePackage)
+		if err != nil {
+			return tempDir, summary{}, err
+		}
+		// The patches were likely written against the version in
+		// which the bug was found.
+		baseVersions, err = foundVersions(client, bugNum)
+		if err != nil {
+			return tempDir, summary{}, err
+		}
+	} else if *sourcePackage == "" && *mergeRequest != "" {
+		*sourcePackage = sourcePackageForProject(project)
+	} else if *sourcePackage == "" {
+		return tempDir, summary{}, fmt.Errorf("-source_package must be specified when merging %q without -bug", local)
 	}
+	log.Printf("will work on package %q, bug %q", *sourcePackage, *bug)
 
-	newChangelogSum, err := sha256of(changelogPath)
+	var series []patch
+	switch {
+	case *mergeRequest != "":
+		// The merge request is fetched once the packaging repository
+		// is checked out, see below.
+	case local != "":
+		series, err = getLocalPatchSeries(*msg, *attachment)
+	default:
+		series, err = getPatchSeries(client, bugNum, *msg, *attachment)
+	}
 	if err != nil {
-		return tempDir, err
+		return tempDir, summary{}, err
 	}
-	if newChangelogSum != oldChangelogSum {
-		log.Printf("%q changed", changelogPath) // TODO: remove in case we can make releaseChangelog() always work
+	if err := checkSignaturePolicy(series); err != nil {
+		return tempDir, summary{}, err
 	}
 
-	if err := releaseChangelog(); err != nil {
-		return tempDir, err
+	checkoutDir, err := checkoutRepository(tempDir, *sourcePackage)
+	if err != nil {
+		return tempDir, summary{}, err
 	}
 
-	if err := buildPackage(); err != nil {
-		return tempDir, err
+	if *mergeRequest != "" {
+		series, err = mergeRequestSeries(mr, project.HTTPURLToRepo)
+		if err != nil {
+			return tempDir, summary{}, err
+		}
+		if err := checkSignaturePolicy(series); err != nil {
+			return tempDir, summary{}, err
+		}
+	}
+	for idx := range series {
+		series[idx].BaseVersions = baseVersions
 	}
 
-	// TODO: run lintian, include result in report
+	format, err := sourceFormat(checkoutDir)
+	if err != nil {
+		return tempDir, summary{}, err
+	}
+	if *gbpPQ && format != "3.0 (quilt)" {
+		return tempDir, summary{}, fmt.Errorf("-gbp_pq requires source format 3.0 (quilt), but %q uses %q", *sourcePackage, format)
+	}
 
-	return tempDir, nil
+	output, err := newCommand("git", "rev-parse", "HEAD").Output()
+	if err != nil {
+		return tempDir, summary{}, err
+	}
+	head := strings.TrimSpace(string(output))
+
+	var merged int
+	var applied error
+	for idx, patch := range series {
+		// Each patch of the series overwrites the previous one, so
+		// that the failing patch remains available for inspection.
+		if err := ioutil.WriteFile(filepath.Join(tempDir, patchFileName), patch.Data, 0600); err != nil {
+			return tempDir, summary{}, err
+		}
+
+		err := checkApplied(patch)
+		if err == nil && format == "3.0 (quilt)" && patch.touchesUpstream() {
+			err = checkQuiltApplied(checkoutDir, patch)
+		}
+		if err != nil {
+			if _, ok := err.(*appliedError); !ok {
+				return tempDir, summary{}, err
+			}
+			log.Printf("Skipping patch %d/%d (%q from message #%d): %v", idx+1, len(series), patch.Filename, patch.MsgNum, err)
+			applied = err
+			continue
+		}
+
+		message := commitMessage(patch, idx, len(series), *bug)
+		if format == "3.0 (quilt)" && patch.touchesUpstream() {
+			if *gbpPQ {
+				err = mergePQPatch(checkoutDir, patch, message, *bug)
+			} else {
+				err = mergeQuiltPatch(checkoutDir, patch, message, *bug)
+			}
+		} else {
+			err = mergePatch(checkoutDir, patch, message, *bug)
+		}
+		if err != nil {
+			return tempDir, summary{}, fmt.Errorf("Merging patch %d/%d (%q from message #%d): %v", idx+1, len(series), patch.Filename, patch.MsgNum, err)
+		}
+		if err := ensureCloses(checkoutDir, patch, *bug); err != nil {
+			return tempDir, summary{}, err
+		}
+		merged++
+	}
+	if merged == 0 {
+		// All patches were merged already, so there is nothing to
+		// release or build.
+		return tempDir, summary{}, applied
+	}
+
+	if err := releaseChangelog(checkoutDir, head, suite); err != nil {
+		return tempDir, summary{}, err
+	}
+
+	result, err := buildPackage(checkoutDir, b, suite)
+	if err != nil {
+		return tempDir, summary{}, err
+	}
+	log.Printf("Built %q", result.Changes)
+	log.Printf("Build information: %q", result.Buildinfo)
+	for _, deb := range result.Debs {
+		log.Printf("Binary package: %q", deb)
+	}
+
+	s := summary{Suite: suite, Build: result}
+	if *autopkgtestEnabled {
+		s.Autopkgtest, err = runAutopkgtest(tempDir, checkoutDir, result, virt, suite)
+		if err != nil {
+			return tempDir, s, err
+		}
+	}
+	if *lintianEnabled {
+		report, err := runLintian(tempDir, checkoutDir, result)
+		if err != nil {
+			return tempDir, s, err
+		}
+		s.Lintian = &report
+		if *failOnNewLintianErrors && len(report.Errors) > 0 {
+			lines := make([]string, len(report.Errors))
+			for idx, tag := range report.Errors {
+				lines[idx] = "  " + tag.String()
+			}
+			return tempDir, s, fmt.Errorf("lintian reported %d new errors:\n%s", len(report.Errors), strings.Join(lines, "\n"))
+		}
+	}
+
+	return tempDir, s, nil
 }
 
 func main() {
 	flag.Parse()
 
-	if *filterChangelogMode {
-		if flag.NArg() != 1 {
-			log.Fatalf("Syntax: %s -filter_changelog <path>", os.Args[0])
+	if *list {
+		if *sourcePackage == "" {
+			log.Fatalf("Syntax: %s -list -source_package=<package>", os.Args[0])
 		}
-
-		if err := filterChangelog(flag.Arg(0)); err != nil {
+		tempDir, err := listPendingPatches(debbugs.DefaultURL, *sourcePackage, os.Stdout)
+		if err != nil {
 			log.Fatal(err)
 		}
+		log.Printf("Logs are available in %q", tempDir)
 		return
 	}
 
 	*bug = strings.TrimPrefix(*bug, "#")
 
-	// TODO: infer sourcePackage from --bug
-	log.Printf("will work on package %q, bug %q", *sourcePackage, *bug)
-
-	tempDir, err := mergeAndBuild(soapAddress)
+	tempDir, s, err := mergeAndBuild(debbugs.DefaultURL)
+	if applied, ok := err.(*appliedError); ok {
+		log.Printf("Nothing to merge, the patch is %v", applied)
+		if *bug != "" {
+			for _, line := range applied.closeHint(*bug) {
+				log.Printf("%s", line)
+			}
+		}
+		return
+	}
 	if err != nil {
 		log.Fatal(err)
 	}
 
 	log.Printf("Merge and build successful!")
+	if s.Lintian != nil {
+		for _, line := range s.Lintian.Lines() {
+			log.Printf("%s", line)
+		}
+	}
+	if s.Autopkgtest != nil {
+		for _, line := range s.Autopkgtest.Lines() {
+			log.Printf("%s", line)
+		}
+	}
 	log.Printf("Please introspect the resulting Debian package and git repository, then push and upload:")
 	log.Printf("cd %q", tempDir)
 	log.Printf("(cd repo && git push)")
-	log.Printf("(cd export && debsign *.changes && dput *.changes)")
+	log.Printf("(cd export && debsign *.changes && %s)", dputCommand(s.Suite))
 }
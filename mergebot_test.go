@@ -4,15 +4,13 @@ import (
 	"flag"
 	"fmt"
 	"io/ioutil"
-	"log"
-	"net/http"
-	"net/http/httptest"
 	"os"
 	"os/exec"
 	"path/filepath"
-	"strings"
 	"testing"
 
+	"github.com/Debian/mergebot/debbugs"
+	"github.com/Debian/mergebot/debbugs/debbugstest"
 	"github.com/Debian/mergebot/loggedexec"
 )
 
@@ -20,41 +18,44 @@ var (
 	skipTestCleanup = flag.Bool("skip_test_cleanup", false, "Skip cleaning up the temporary directory in which the test case works for investigating what went wrong.")
 )
 
-func init() {
-	flag.Parse()
-	if *filterChangelogMode {
-		if flag.NArg() != 1 {
-			log.Fatalf("Syntax: %s -filter_changelog <path>", os.Args[0])
+// setenv sets the environment variable key to value and returns a
+// function which restores its previous value.
+func setenv(key, value string) func() {
+	old, ok := os.LookupEnv(key)
+	os.Setenv(key, value)
+	return func() {
+		if ok {
+			os.Setenv(key, old)
+		} else {
+			os.Unsetenv(key)
 		}
-
-		if err := filterChangelog(flag.Arg(0)); err != nil {
-			log.Fatal(err)
-		}
-
-		os.Exit(0)
-	}
-}
-
-func distributionIsUbuntu() bool {
-	contents, err := ioutil.ReadFile("/etc/lsb-release")
-	if err != nil {
-		return false
 	}
-	return strings.Contains(string(contents), "DISTRIB_ID=Ubuntu")
 }
 
 func TestMergeAndBuild(t *testing.T) {
-	os.Setenv("DEBFULLNAME", "Test Case")
-	os.Setenv("DEBEMAIL", "test@case")
-
+	defer setenv("DEBFULLNAME", "Test Case")()
+	defer setenv("DEBEMAIL", "test@case")()
+
+	// mergeAndBuild() overwrites newCommand, and the flags must not
+	// leak into other tests either.
+	oldNewCommand := newCommand
+	oldSourcePackage, oldBug := *sourcePackage, *bug
+	defer func() {
+		newCommand = oldNewCommand
+		*sourcePackage, *bug = oldSourcePackage, oldBug
+	}()
 	flag.Set("source_package", "min")
 	flag.Set("bug", "1")
 
-	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", `multipart/related; type="text/xml"; start="<main_envelope>"; boundary="_----------=_146851316918670990"`)
-		http.ServeFile(w, r, "testdata/minimal.soap")
-	}))
-	defer ts.Close()
+	srv := debbugstest.NewServer()
+	defer srv.Close()
+	srv.Statuses[1] = debbugs.Status{
+		Package: "min",
+		Source:  "min",
+		Subject: "wit: please make the build reproducible",
+		Tags:    "patch",
+	}
+	srv.Recordings["get_bug_log"] = "testdata/minimal.soap"
 
 	tempDir, err := ioutil.TempDir("", "test-merge-and-build-")
 	if err != nil {
@@ -67,7 +68,7 @@ func TestMergeAndBuild(t *testing.T) {
 	}
 
 	// To make mergeAndBuild() place its temporary directory inside the test’s
-	os.Setenv("TMPDIR", tempDir)
+	defer setenv("TMPDIR", tempDir)()
 
 	if err := exec.Command("cp", "-r", "testdata/minimal-debian-package", tempDir).Run(); err != nil {
 		t.Fatal(err)
@@ -100,9 +101,9 @@ echo "git\tfile://%s/.git"
 	if err := ioutil.WriteFile(filepath.Join(tempDir, "debcheckout"), []byte(debcheckoutDiversion), 0755); err != nil {
 		t.Fatal(err)
 	}
-	os.Setenv("PATH", tempDir+":"+os.Getenv("PATH"))
+	defer setenv("PATH", tempDir+":"+os.Getenv("PATH"))()
 
-	mergeTempDir, err := mergeAndBuild(ts.URL)
+	mergeTempDir, _, err := mergeAndBuild(srv.URL)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -115,9 +116,6 @@ echo "git\tfile://%s/.git"
 	}
 
 	version := "1.1"
-	if distributionIsUbuntu() {
-		version = "1.0ubuntu1"
-	}
 
 	cmd = loggedexec.Command("git", "log", "--format=%an %ae %s", "HEAD~2..")
 	cmd.LogDir = tempDir
@@ -145,3 +143,94 @@ debian/%s
 		t.Fatalf("Unexpected git tags after push: got %q, want %q", got, want)
 	}
 }
+
+func TestCommitMessage(t *testing.T) {
+	for _, tt := range []struct {
+		p          patch
+		idx, total int
+		bug        string
+		want       string
+	}{
+		{patch{Subject: "wit: FTBFS"}, 0, 1, "1", "Fix for “wit: FTBFS” (Closes: #1)"},
+		{patch{Subject: "wit: FTBFS"}, 1, 2, "1", "Fix for “wit: FTBFS”, part 2/2 (Closes: #1)"},
+		{patch{Subject: "wit: FTBFS"}, 0, 1, "", "Fix for “wit: FTBFS”"},
+		{patch{Filename: "wit.diff"}, 0, 1, "", "Apply wit.diff"},
+	} {
+		if got := commitMessage(tt.p, tt.idx, tt.total, tt.bug); got != tt.want {
+			t.Errorf("commitMessage(%+v, %d, %d, %q): got %q, want %q", tt.p, tt.idx, tt.total, tt.bug, got, tt.want)
+		}
+	}
+}
+
+func TestMergePatchGitAmFallback(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "test-merge-patch-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	repo := setupQuiltRepo(t, tempDir)
+	defer useRepo(repo)()
+	readme := filepath.Join(repo, "README")
+	for _, step := range []struct {
+		contents string
+		message  string
+	}{
+		{"a\nb\nc\nd\ne\n", "Extend README"},
+		{"a\nb\nC\nd\ne\n", "Capitalize c"},
+	} {
+		if err := ioutil.WriteFile(readme, []byte(step.contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+		gitIn(t, repo, "commit", "-a", "-m", step.message)
+	}
+	formatPatch := gitIn(t, repo, "format-patch", "--stdout", "-1")
+	gitIn(t, repo, "reset", "--hard", "HEAD^")
+
+	// Modify the context of the patch, so that git am fails.
+	if err := ioutil.WriteFile(readme, []byte("A\nb\nc\nd\ne\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	gitIn(t, repo, "commit", "-a", "-m", "Capitalize a")
+
+	p := patch{Data: []byte(formatPatch + "\n"), Author: "Chris Lamb <lamby@debian.org>"}
+	if !p.isGitFormatPatch() {
+		t.Fatalf("Patch is not recognized as git format-patch output")
+	}
+	if err := ioutil.WriteFile(filepath.Join(tempDir, patchFileName), p.Data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := mergePatch(repo, p, "Fix for “min: capitalize c” (Closes: #1)", "1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(repo, ".git", "rebase-apply")); !os.IsNotExist(err) {
+		t.Fatalf("git am session was not aborted: %v", err)
+	}
+	contents, err := ioutil.ReadFile(readme)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(contents), "A\nb\nC\nd\ne\n"; got != want {
+		t.Fatalf("Unexpected README: got %q, want %q", got, want)
+	}
+	if got, want := gitIn(t, repo, "log", "-1", "--format=%an: %s"), "Chris Lamb: Fix for “min: capitalize c” (Closes: #1)"; got != want {
+		t.Fatalf("Unexpected commit: got %q, want %q", got, want)
+	}
+}
+
+func TestCheckFlags(t *testing.T) {
+	oldMbox, oldEml, oldBuilder := *mbox, *eml, *builderName
+	defer func() { *mbox, *eml, *builderName = oldMbox, oldEml, oldBuilder }()
+
+	*mbox, *eml = "series.mbox", "831331.eml"
+	if _, err := checkFlags(); err == nil {
+		t.Fatalf("checkFlags unexpectedly accepted both -mbox and -eml")
+	}
+
+	*eml, *builderName = "", "debuild"
+	_, err := checkFlags()
+	if got, want := fmt.Sprint(err), `Unknown -builder "debuild", expected one of cowbuilder, docker, dpkg-buildpackage, pbuilder, podman, sbuild`; got != want {
+		t.Fatalf("checkFlags: got error %q, want %q", got, want)
+	}
+}
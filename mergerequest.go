@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/Debian/mergebot/gitlab"
+)
+
+var mergeRequest = flag.String("merge_request",
+	"",
+	"Salsa/GitLab merge request to merge instead of a patch from -bug, e.g. https://salsa.debian.org/debian/wit/-/merge_requests/3 or debian/wit!3. Set GITLAB_TOKEN for private projects on salsa.debian.org.")
+
+// gitlabToken returns the token in the GITLAB_TOKEN environment
+// variable if baseURL refers to salsa.debian.org, so that the token is
+// never sent to other GitLab instances.
+func gitlabToken(baseURL string) string {
+	token := os.Getenv("GITLAB_TOKEN")
+	if token == "" {
+		return ""
+	}
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return ""
+	}
+	salsa, err := url.Parse(gitlab.DefaultURL)
+	if err != nil {
+		return ""
+	}
+	if u.Scheme != salsa.Scheme || u.Host != salsa.Host {
+		log.Printf("Not sending GITLAB_TOKEN to %s, it is only used for %s", baseURL, gitlab.DefaultURL)
+		return ""
+	}
+	return token
+}
+
+// getMergeRequest returns the merge request specified by mr (see
+// -merge_request) and its target project, whose repository contains
+// the merge request’s head ref.
+func getMergeRequest(mr string) (gitlab.MergeRequest, gitlab.Project, error) {
+	baseURL, projectPath, iid, err := gitlab.ParseMergeRequest(mr, gitlab.DefaultURL)
+	if err != nil {
+		return gitlab.MergeRequest{}, gitlab.Project{}, err
+	}
+	client := gitlab.NewClient(baseURL)
+	client.Token = gitlabToken(baseURL)
+
+	project, err := client.GetProject(projectPath)
+	if err != nil {
+		return gitlab.MergeRequest{}, gitlab.Project{}, err
+	}
+	result, err := client.GetMergeRequest(projectPath, iid)
+	if err != nil {
+		return gitlab.MergeRequest{}, gitlab.Project{}, err
+	}
+	if got, want := result.State, "opened"; got != want {
+		return gitlab.MergeRequest{}, gitlab.Project{}, fmt.Errorf("Merge request %s is %s, not %s", result.WebURL, got, want)
+	}
+	log.Printf("Merging merge request %s (“%s” by %s, branch %q)", result.WebURL, result.Title, result.Author.Username, result.SourceBranch)
+	return result, project, nil
+}
+
+// sourcePackageForProject returns the source package name which the
+// project is named after by convention, e.g. wit for debian/wit.
+func sourcePackageForProject(project gitlab.Project) string {
+	return path.Base(project.PathWithNamespace)
+}
+
+// mergeRequestSeries fetches the head of mr from repoURL into the
+// packaging repository and returns the commits which are not yet part
+// of the packaging repository’s HEAD as a git format-patch series, so
+// that they are merged using git am, retaining their authors and
+// commit messages.
+func mergeRequestSeries(mr gitlab.MergeRequest, repoURL string) ([]patch, error) {
+	if err := newCommand("git", "fetch", repoURL, mr.HeadRef()).Run(); err != nil {
+		return nil, err
+	}
+	head, err := newCommand("git", "rev-parse", "FETCH_HEAD").Output()
+	if err != nil {
+		return nil, err
+	}
+	if mr.SHA != "" && strings.TrimSpace(string(head)) != mr.SHA {
+		log.Printf("Merge request head %q differs from %q as reported by the API, the merge request was updated in the meantime", strings.TrimSpace(string(head)), mr.SHA)
+	}
+	base, err := newCommand("git", "merge-base", "HEAD", "FETCH_HEAD").Output()
+	if err != nil {
+		return nil, err
+	}
+	mbox, err := newCommand("git", "format-patch", "--stdout", strings.TrimSpace(string(base))+"..FETCH_HEAD").Output()
+	if err != nil {
+		return nil, err
+	}
+	candidates, err := patchesFromMbox(bytes.NewReader(mbox))
+	if err != nil {
+		return nil, err
+	}
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("Merge request %s does not contain any commits which are not yet merged", mr.WebURL)
+	}
+	series, ok := formatPatchSeries(candidates)
+	if !ok {
+		return nil, fmt.Errorf("Merge request %s: could not parse git format-patch output", mr.WebURL)
+	}
+	return series, nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Debian/mergebot/gitlab"
+	"github.com/Debian/mergebot/gitlab/gitlabtest"
+	"github.com/Debian/mergebot/loggedexec"
+)
+
+func gitIn(t *testing.T, dir string, args ...string) string {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=Chris Lamb",
+		"GIT_AUTHOR_EMAIL=lamby@debian.org",
+		"GIT_COMMITTER_NAME=Test Case",
+		"GIT_COMMITTER_EMAIL=test@case")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("%v: %v\n%s", cmd.Args, err, output)
+	}
+	return strings.TrimSpace(string(output))
+}
+
+// setupMergeRequestRemote creates a git repository in dir/remote which
+// carries a merge request with two commits under
+// refs/merge-requests/1/head (like GitLab does), and a clone of the
+// repository in dir/repo.
+func setupMergeRequestRemote(t *testing.T, dir string) (remote, repo string) {
+	remote = filepath.Join(dir, "remote")
+	if err := exec.Command("cp", "-r", "testdata/minimal-debian-package", remote).Run(); err != nil {
+		t.Fatal(err)
+	}
+	gitIn(t, remote, "init")
+	gitIn(t, remote, "symbolic-ref", "HEAD", "refs/heads/master")
+	gitIn(t, remote, "add", ".")
+	gitIn(t, remote, "commit", "-m", "Initial commit")
+	gitIn(t, remote, "checkout", "-b", "reproducible")
+	for idx, line := range []string{"Homepage: https://example.net/", "Testsuite: autopkgtest"} {
+		control := filepath.Join(remote, "debian", "control")
+		contents, err := ioutil.ReadFile(control)
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents = []byte(strings.Replace(string(contents), "\n\n", "\n"+line+"\n\n", 1))
+		if err := ioutil.WriteFile(control, contents, 0644); err != nil {
+			t.Fatal(err)
+		}
+		gitIn(t, remote, "commit", "-a", "-m", fmt.Sprintf("Add %s field (%d/2)", strings.Split(line, ":")[0], idx+1))
+	}
+	gitIn(t, remote, "update-ref", "refs/merge-requests/1/head", "reproducible")
+	gitIn(t, remote, "checkout", "master")
+	gitIn(t, remote, "branch", "-D", "reproducible")
+
+	repo = filepath.Join(dir, "repo")
+	gitIn(t, dir, "clone", remote, repo)
+	return remote, repo
+}
+
+func TestMergeRequestSeries(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "test-merge-request-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+	remote, repo := setupMergeRequestRemote(t, tempDir)
+
+	srv := gitlabtest.NewServer()
+	defer srv.Close()
+	srv.Projects["debian/min"] = gitlab.Project{
+		ID:                1,
+		PathWithNamespace: "debian/min",
+		HTTPURLToRepo:     "file://" + remote,
+	}
+	srv.MergeRequests["debian/min"] = map[int]gitlab.MergeRequest{
+		1: {
+			IID:          1,
+			Title:        "Add Homepage and Testsuite fields",
+			State:        "opened",
+			Author:       gitlab.User{Username: "lamby", Name: "Chris Lamb"},
+			SourceBranch: "reproducible",
+			TargetBranch: "master",
+			WebURL:       srv.URL + "/debian/min/-/merge_requests/1",
+		},
+	}
+
+	mr, project, err := getMergeRequest(srv.URL + "/debian/min/-/merge_requests/1")
+	if err != nil {
+		t.Fatalf("getMergeRequest: %v", err)
+	}
+	if got, want := sourcePackageForProject(project), "min"; got != want {
+		t.Fatalf("Incorrect source package: got %q, want %q", got, want)
+	}
+
+	oldNewCommand := newCommand
+	defer func() { newCommand = oldNewCommand }()
+	newCommand = func(name string, arg ...string) *loggedexec.LoggedCmd {
+		cmd := loggedexec.Command(name, arg...)
+		cmd.Dir = repo
+		cmd.Env = append(os.Environ(), "GIT_COMMITTER_NAME=Test Case", "GIT_COMMITTER_EMAIL=test@case")
+		return cmd
+	}
+
+	series, err := mergeRequestSeries(mr, project.HTTPURLToRepo)
+	if err != nil {
+		t.Fatalf("mergeRequestSeries: %v", err)
+	}
+	if got, want := len(series), 2; got != want {
+		t.Fatalf("Unexpected number of patches: got %d, want %d", got, want)
+	}
+	for idx, p := range series {
+		if !p.isGitFormatPatch() {
+			t.Fatalf("Patch %d is not recognized as git format-patch output", idx+1)
+		}
+		if got, want := p.Author, "Chris Lamb <lamby@debian.org>"; got != want {
+			t.Fatalf("Incorrect author of patch %d: got %q, want %q", idx+1, got, want)
+		}
+		if err := ioutil.WriteFile(filepath.Join(tempDir, patchFileName), p.Data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := mergePatch(repo, p, "", ""); err != nil {
+			t.Fatalf("Merging patch %d: %v", idx+1, err)
+		}
+	}
+
+	if got, want := gitIn(t, repo, "log", "--format=%an: %s", "-2"), "Chris Lamb: Add Testsuite field (2/2)\nChris Lamb: Add Homepage field (1/2)"; got != want {
+		t.Fatalf("Unexpected git log: got %q, want %q", got, want)
+	}
+
+	// Once merged, there is nothing left to merge.
+	if _, err := mergeRequestSeries(mr, project.HTTPURLToRepo); err == nil {
+		t.Fatalf("mergeRequestSeries unexpectedly succeeded for an already merged merge request")
+	}
+
+	srv.MergeRequests["debian/min"][1] = gitlab.MergeRequest{IID: 1, State: "merged"}
+	if _, _, err := getMergeRequest(srv.URL + "/debian/min/-/merge_requests/1"); err == nil {
+		t.Fatalf("getMergeRequest unexpectedly accepted a merged merge request")
+	}
+}
+
+func TestGitlabToken(t *testing.T) {
+	defer setenv("GITLAB_TOKEN", "secret")()
+	for _, tt := range []struct {
+		baseURL string
+		want    string
+	}{
+		{gitlab.DefaultURL, "secret"},
+		{"https://gitlab.example.net", ""},
+		{"https://salsa.debian.org.example.net", ""},
+		{"http://salsa.debian.org", ""},
+	} {
+		if got := gitlabToken(tt.baseURL); got != tt.want {
+			t.Errorf("gitlabToken(%q): got %q, want %q", tt.baseURL, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+)
+
+var gbpPQ = flag.Bool("gbp_pq",
+	false,
+	"Merge changes to upstream files of 3.0 (quilt) packages via gbp pq, i.e. as a commit on the patch-queue branch which is then exported to debian/patches. Use this for repositories which are maintained using gbp pq.")
+
+// pqCommitMessage returns the commit message for the patch-queue
+// commit of p, which gbp pq export turns into the patch header.
+func pqCommitMessage(p patch, bug string) string {
+	return fmt.Sprintf("%s\n\n%s", quiltDescription(p), strings.TrimSpace(dep3Fields(p, bug)))
+}
+
+// mergePQPatch merges p into a 3.0 (quilt) package using gbp pq:
+// changes to upstream files are committed to the patch-queue branch
+// (retaining the author) and exported to debian/patches, changes
+// within debian/ are applied directly. Both are committed to the
+// debian branch using message.
+func mergePQPatch(checkoutDir string, p patch, message, bug string) error {
+	author, err := patchAuthor(p)
+	if err != nil {
+		return err
+	}
+
+	if err := newCommand("gbp", "pq", "import").Run(); err != nil {
+		return err
+	}
+
+	debian, upstream := splitUpstream(p.Data)
+	if p.isGitFormatPatch() && len(debian) == 0 {
+		// git am retains the contributor’s commit message, too. The
+		// DEP-3 fields are added as trailers, which gbp pq export turns
+		// into the patch header.
+		if err := gitAm(""); err != nil {
+			return err
+		}
+		if err := appendTrailers(strings.TrimSpace(dep3Fields(p, bug))); err != nil {
+			return err
+		}
+	} else {
+		if err := applyDiffs(checkoutDir, upstream, p.BaseVersions); err != nil {
+			return err
+		}
+		if err := gitCommit(author, pqCommitMessage(p, bug)); err != nil {
+			return err
+		}
+	}
+
+	// Switches back to the debian branch and removes the patch-queue
+	// branch, which was only created for this merge.
+	if err := newCommand("gbp", "pq", "export", "--drop").Run(); err != nil {
+		return err
+	}
+	log.Printf("Exported the patch-queue to debian/patches")
+
+	if err := applyDiffs(checkoutDir, debian, p.BaseVersions); err != nil {
+		return err
+	}
+	return gitCommit(author, message)
+}
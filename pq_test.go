@@ -0,0 +1,108 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPQCommitMessage(t *testing.T) {
+	p := patch{
+		Subject: "min: please clarify README",
+		MsgNum:  5,
+		Date:    time.Date(2016, 7, 14, 17, 20, 12, 0, time.UTC),
+	}
+	if got, want := pqCommitMessage(p, "1"), `min: please clarify README
+
+Bug-Debian: https://bugs.debian.org/1
+Origin: other, https://bugs.debian.org/1#5
+Last-Update: 2016-07-14`; got != want {
+		t.Fatalf("Incorrect commit message: got %q, want %q", got, want)
+	}
+}
+
+func TestAppendTrailers(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "test-append-trailers-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+	repo := setupQuiltRepo(t, tempDir)
+	defer useRepo(repo)()
+
+	if err := ioutil.WriteFile(filepath.Join(repo, "README"), []byte("min is a tiny package\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	gitIn(t, repo, "commit", "-a", "-m", "Clarify README\n\nBug-Debian: https://bugs.debian.org/1")
+
+	p := patch{MsgNum: 5, Date: time.Date(2016, 7, 14, 17, 20, 12, 0, time.UTC)}
+	for i := 0; i < 2; i++ {
+		// Appending the same trailers again does not duplicate them.
+		if err := appendTrailers(strings.TrimSpace(dep3Fields(p, "1"))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, want := gitIn(t, repo, "log", "-1", "--format=%B"), `Clarify README
+
+Bug-Debian: https://bugs.debian.org/1
+Origin: other, https://bugs.debian.org/1#5
+Last-Update: 2016-07-14`; got != want {
+		t.Fatalf("Incorrect commit message: got %q, want %q", got, want)
+	}
+}
+
+func TestMergePQPatch(t *testing.T) {
+	if _, err := exec.LookPath("gbp"); err != nil {
+		t.Skip("gbp not installed")
+	}
+
+	tempDir, err := ioutil.TempDir("", "test-merge-pq-patch-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+	repo := setupQuiltRepo(t, tempDir)
+	defer useRepo(repo)()
+
+	p := patch{
+		Author:  "Chris Lamb <lamby@debian.org>",
+		Subject: "min: please clarify README",
+		Data:    []byte(quiltTestPatch),
+		MsgNum:  5,
+	}
+	if err := ioutil.WriteFile(filepath.Join(tempDir, patchFileName), p.Data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := mergePQPatch(repo, p, "Fix for “min: please clarify README” (Closes: #1)", "1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := gitIn(t, repo, "rev-parse", "--abbrev-ref", "HEAD"), "master"; got != want {
+		t.Fatalf("Not on the debian branch after merging: got %q, want %q", got, want)
+	}
+	series, err := ioutil.ReadFile(filepath.Join(repo, "debian", "patches", "series"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := strings.TrimSpace(string(series))
+	exported, err := ioutil.ReadFile(filepath.Join(repo, "debian", "patches", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(exported), "From: Chris Lamb <lamby@debian.org>") {
+		t.Fatalf("Exported patch %q does not retain the author: %q", name, exported)
+	}
+	if strings.Contains(string(exported), "debian/control") {
+		t.Fatalf("Exported patch %q contains changes within debian/: %q", name, exported)
+	}
+	if got, want := gitIn(t, repo, "log", "-1", "--format=%an"), "Chris Lamb"; got != want {
+		t.Fatalf("Incorrect commit author: got %q, want %q", got, want)
+	}
+	if got := gitIn(t, repo, "status", "--porcelain"); got != "" {
+		t.Fatalf("Uncommitted changes left: %q", got)
+	}
+}
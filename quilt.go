@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// sourceFormat returns the source package format of the packaging
+// repository in checkoutDir, e.g. “3.0 (quilt)”. Packages without
+// debian/source/format use format 1.0, see dpkg-source(1).
+func sourceFormat(checkoutDir string) (string, error) {
+	contents, err := ioutil.ReadFile(filepath.Join(checkoutDir, "debian", "source", "format"))
+	if os.IsNotExist(err) {
+		return "1.0", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(contents)), nil
+}
+
+// splitUpstream splits the diff contained in data into the changes to
+// files within debian/ and the changes to upstream files.
+func splitUpstream(data []byte) (debian, upstream []fileDiff) {
+	_, files := parseDiff(data)
+	for _, f := range files {
+		if strings.HasPrefix(f.Name(), "debian/") {
+			debian = append(debian, f)
+		} else {
+			upstream = append(upstream, f)
+		}
+	}
+	return debian, upstream
+}
+
+func joinDiffs(files []fileDiff) []byte {
+	var buf bytes.Buffer
+	for _, f := range files {
+		buf.Write(f.Bytes())
+	}
+	return buf.Bytes()
+}
+
+var slugInvalidRe = regexp.MustCompile(`[^a-z0-9]+`)
+
+// quiltSlug returns the file name (without extension) for a new quilt
+// patch based on p’s subject or file name, e.g. “make-the-build-reproducible”.
+func quiltSlug(p patch) string {
+	subject := p.Subject
+	if idx := strings.Index(subject, ": "); idx > -1 && !strings.Contains(subject[:idx], " ") {
+		// Strip the package name prefix of bug titles, e.g. “wit: ”.
+		subject = subject[idx+2:]
+	}
+	if subject == "" {
+		subject = strings.TrimSuffix(p.Filename, filepath.Ext(p.Filename))
+	}
+	slug := strings.Trim(slugInvalidRe.ReplaceAllString(strings.ToLower(subject), "-"), "-")
+	if len(slug) > 50 {
+		slug = strings.TrimRight(slug[:50], "-")
+	}
+	if slug == "" {
+		slug = "mergebot"
+	}
+	return slug
+}
+
+// dep3Header returns a patch header as described in DEP-3
+// (http://dep.debian.net/deps/dep3/) for p, which was submitted to bug
+// (may be empty).
+func dep3Header(p patch, bug string) string {
+	return fmt.Sprintf("Description: %s\nAuthor: %s\n%s---\n", quiltDescription(p), p.Author, dep3Fields(p, bug))
+}
+
+// dep3Fields returns the Bug-Debian, Origin and Last-Update DEP-3
+// fields for p, which was submitted to bug (may be empty).
+func dep3Fields(p patch, bug string) string {
+	var buf bytes.Buffer
+	if bug != "" {
+		fmt.Fprintf(&buf, "Bug-Debian: https://bugs.debian.org/%s\n", bug)
+	}
+	switch {
+	case bug != "" && p.MsgNum != 0:
+		fmt.Fprintf(&buf, "Origin: other, https://bugs.debian.org/%s#%d\n", bug, p.MsgNum)
+	case *mergeRequest != "":
+		fmt.Fprintf(&buf, "Origin: other, %s\n", *mergeRequest)
+	default:
+		fmt.Fprintf(&buf, "Origin: other\n")
+	}
+	date := p.Date
+	if date.IsZero() {
+		date = time.Now()
+	}
+	fmt.Fprintf(&buf, "Last-Update: %s\n", date.Format("2006-01-02"))
+	return buf.String()
+}
+
+// quiltDescription returns the DEP-3 Description of p, which is the
+// subject of the git commit for git format-patch submissions.
+func quiltDescription(p patch) string {
+	if p.isGitFormatPatch() {
+		if matches := formatPatchDescriptionRe.FindSubmatch(p.Data); matches != nil {
+			return string(matches[1])
+		}
+	}
+	if p.Subject != "" {
+		return p.Subject
+	}
+	return p.Filename
+}
+
+// formatPatchDescriptionRe matches the subject of a git format-patch
+// mail, without the “[PATCH]” prefix.
+var formatPatchDescriptionRe = regexp.MustCompile(`(?m)^Subject: (?:\[PATCH[^\]]*\] )?(.*)$`)
+
+// addQuiltPatch adds data as a new patch to the quilt series in
+// checkoutDir and returns the name of the patch within debian/patches.
+func addQuiltPatch(checkoutDir, slug string, data []byte) (string, error) {
+	patchesDir := filepath.Join(checkoutDir, "debian", "patches")
+	if err := os.MkdirAll(patchesDir, 0755); err != nil {
+		return "", err
+	}
+	name := slug + ".patch"
+	for i := 2; ; i++ {
+		if _, err := os.Stat(filepath.Join(patchesDir, name)); os.IsNotExist(err) {
+			break
+		}
+		name = fmt.Sprintf("%s-%d.patch", slug, i)
+	}
+	if err := ioutil.WriteFile(filepath.Join(patchesDir, name), data, 0644); err != nil {
+		return "", err
+	}
+
+	seriesPath := filepath.Join(patchesDir, "series")
+	series, err := ioutil.ReadFile(seriesPath)
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	if len(series) > 0 && !bytes.HasSuffix(series, []byte("\n")) {
+		series = append(series, '\n')
+	}
+	series = append(series, name+"\n"...)
+	return name, ioutil.WriteFile(seriesPath, series, 0644)
+}
+
+// quiltSeriesEntry is a patch listed in debian/patches/series.
+type quiltSeriesEntry struct {
+	Name string
+
+	// Strip is the patch(1) option for stripping path components,
+	// “-p1” unless specified otherwise in the series file.
+	Strip string
+}
+
+// quiltSeries returns the patches listed in the quilt series of
+// checkoutDir, skipping comments.
+func quiltSeries(checkoutDir string) ([]quiltSeriesEntry, error) {
+	contents, err := ioutil.ReadFile(filepath.Join(checkoutDir, "debian", "patches", "series"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var series []quiltSeriesEntry
+	for _, line := range strings.Split(string(contents), "\n") {
+		if idx := strings.Index(line, "#"); idx > -1 {
+			line = line[:idx]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		entry := quiltSeriesEntry{Name: fields[0], Strip: "-p1"}
+		if len(fields) > 1 && strings.HasPrefix(fields[1], "-p") {
+			entry.Strip = fields[1]
+		}
+		series = append(series, entry)
+	}
+	return series, nil
+}
+
+// sameChanges returns whether a and b make the same changes, ignoring
+// headers and hunk positions.
+func sameChanges(a, b []fileDiff) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for idx := range a {
+		if a[idx].Name() != b[idx].Name() || len(a[idx].Hunks) != len(b[idx].Hunks) {
+			return false
+		}
+		for h := range a[idx].Hunks {
+			if strings.Join(a[idx].Hunks[h].Lines, "") != strings.Join(b[idx].Hunks[h].Lines, "") {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// checkQuiltApplied returns an *appliedError if the changes p makes to
+// upstream files are already contained in a patch of the quilt series
+// in checkoutDir. checkApplied cannot detect this, as quilt patches
+// are not applied in the packaging repository.
+func checkQuiltApplied(checkoutDir string, p patch) error {
+	_, upstream := splitUpstream(p.Data)
+	series, err := quiltSeries(checkoutDir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range series {
+		path := filepath.Join("debian", "patches", entry.Name)
+		contents, err := ioutil.ReadFile(filepath.Join(checkoutDir, path))
+		if err != nil {
+			return err
+		}
+		if _, files := parseDiff(contents); !sameChanges(upstream, files) {
+			continue
+		}
+		output, err := newCommand("git", "log", "-1", "--format=%H", "--", path).Output()
+		if err != nil {
+			return err
+		}
+		commit := strings.TrimSpace(string(output))
+		applied := &appliedError{Commit: commit, QuiltPatch: entry.Name}
+		if commit != "" {
+			applied.Version = releasedIn(commit)
+		}
+		return applied
+	}
+	return nil
+}
+
+// checkQuiltSeries verifies that all patches of the quilt series in
+// checkoutDir apply in order (without fuzz, like dpkg-source applies
+// them) to the upstream files of HEAD, so that a new patch does not
+// break the build.
+func checkQuiltSeries(checkoutDir string) error {
+	series, err := quiltSeries(checkoutDir)
+	if err != nil {
+		return err
+	}
+	seriesDir := filepath.Join(filepath.Dir(checkoutDir), "quilt-series")
+	if err := newCommand("git", "worktree", "add", "--detach", seriesDir, "HEAD").Run(); err != nil {
+		return err
+	}
+	defer func() {
+		if err := os.RemoveAll(seriesDir); err != nil {
+			log.Printf("Could not remove %q: %v", seriesDir, err)
+		}
+		if err := newCommand("git", "worktree", "prune").Run(); err != nil {
+			log.Printf("Could not prune worktrees: %v", err)
+		}
+	}()
+
+	for _, entry := range series {
+		cmd := newCommand("patch", entry.Strip, "--batch", "--forward", "--fuzz=0", "-i", filepath.Join(checkoutDir, "debian", "patches", entry.Name))
+		cmd.Dir = seriesDir
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("debian/patches/%s does not apply on top of the preceding patches of the series: %v", entry.Name, err)
+		}
+	}
+	return nil
+}
+
+// applyDiffs applies files (if any) to the packaging repository in
+// checkoutDir, see applyPatch for baseVersions. As applyPatch reads
+// patchFileName, it is overwritten.
+func applyDiffs(checkoutDir string, files []fileDiff, baseVersions []string) error {
+	if len(files) == 0 {
+		return nil
+	}
+	tempDir := filepath.Dir(checkoutDir)
+	if err := ioutil.WriteFile(filepath.Join(tempDir, patchFileName), joinDiffs(files), 0600); err != nil {
+		return err
+	}
+	return applyPatch(checkoutDir, baseVersions)
+}
+
+// mergeQuiltPatch merges p into a 3.0 (quilt) package: changes to
+// upstream files are added as a new patch to debian/patches, changes
+// within debian/ are applied directly. Both are committed using
+// message.
+func mergeQuiltPatch(checkoutDir string, p patch, message, bug string) error {
+	var err error
+	if p.Author, err = patchAuthor(p); err != nil {
+		return err
+	}
+
+	debian, upstream := splitUpstream(p.Data)
+	if err := applyDiffs(checkoutDir, debian, p.BaseVersions); err != nil {
+		return err
+	}
+
+	data := append([]byte(dep3Header(p, bug)), joinDiffs(upstream)...)
+	name, err := addQuiltPatch(checkoutDir, quiltSlug(p), data)
+	if err != nil {
+		return err
+	}
+	log.Printf("Added changes to upstream files as debian/patches/%s", name)
+	if err := checkQuiltSeries(checkoutDir); err != nil {
+		return err
+	}
+
+	return gitCommit(p.Author, message)
+}
+
+// touchesUpstream returns whether p modifies any file outside of
+// debian/.
+func (p patch) touchesUpstream() bool {
+	_, upstream := splitUpstream(p.Data)
+	return len(upstream) > 0
+}
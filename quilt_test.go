@@ -0,0 +1,211 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Debian/mergebot/loggedexec"
+)
+
+func TestQuiltSlug(t *testing.T) {
+	for _, tt := range []struct {
+		p    patch
+		want string
+	}{
+		{patch{Subject: "wit: please make the build reproducible"}, "please-make-the-build-reproducible"},
+		{patch{Subject: "FTBFS: error: ‘foo’ undeclared"}, "error-foo-undeclared"},
+		{patch{Filename: "wit.diff.txt"}, "wit-diff"},
+		{patch{Subject: strings.Repeat("very ", 20) + "long"}, "very-very-very-very-very-very-very-very-very-very"},
+		{patch{}, "mergebot"},
+	} {
+		if got := quiltSlug(tt.p); got != tt.want {
+			t.Errorf("quiltSlug(%+v): got %q, want %q", tt.p, got, tt.want)
+		}
+	}
+}
+
+func TestDep3Header(t *testing.T) {
+	p := patch{
+		Author:  "Chris Lamb <lamby@debian.org>",
+		Subject: "wit: please make the build reproducible",
+		MsgNum:  5,
+		Date:    time.Date(2016, 7, 14, 17, 20, 12, 0, time.UTC),
+	}
+	if got, want := dep3Header(p, "831331"), `Description: wit: please make the build reproducible
+Author: Chris Lamb <lamby@debian.org>
+Bug-Debian: https://bugs.debian.org/831331
+Origin: other, https://bugs.debian.org/831331#5
+Last-Update: 2016-07-14
+---
+`; got != want {
+		t.Fatalf("Incorrect DEP-3 header: got %q, want %q", got, want)
+	}
+
+	p.Data, _ = ioutil.ReadFile("testdata/series/0002-Add-Homepage-field.patch")
+	if got, want := quiltDescription(p), "Add Homepage field"; got != want {
+		t.Fatalf("Incorrect description for git format-patch submission: got %q, want %q", got, want)
+	}
+}
+
+const quiltTestPatch = `--- a/README
++++ b/README
+@@ -1 +1 @@
+-min is a minimal package
++min is a minimal Debian package
+--- a/debian/control
++++ b/debian/control
+@@ -1,3 +1,4 @@
+ Source: min
++Homepage: https://example.net/
+ Priority: extra
+ Section: devel
+`
+
+// setupQuiltRepo creates a git repository of a 3.0 (quilt) version of
+// the minimal Debian package, including an upstream file, in
+// tempDir/repo.
+func setupQuiltRepo(t *testing.T, tempDir string) string {
+	repo := filepath.Join(tempDir, "repo")
+	if err := exec.Command("cp", "-r", "testdata/minimal-debian-package", repo).Run(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(repo, "debian", "source"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for path, contents := range map[string]string{
+		"debian/source/format": "3.0 (quilt)\n",
+		"README":               "min is a minimal package\n",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(repo, path), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	gitIn(t, repo, "init")
+	gitIn(t, repo, "symbolic-ref", "HEAD", "refs/heads/master")
+	gitIn(t, repo, "add", ".")
+	gitIn(t, repo, "commit", "-m", "Initial commit")
+	return repo
+}
+
+// useRepo makes newCommand run all commands in repo and returns a
+// function which restores newCommand.
+func useRepo(repo string) func() {
+	oldNewCommand := newCommand
+	newCommand = func(name string, arg ...string) *loggedexec.LoggedCmd {
+		cmd := loggedexec.Command(name, arg...)
+		cmd.Dir = repo
+		cmd.Env = append(os.Environ(), "GIT_COMMITTER_NAME=Test Case", "GIT_COMMITTER_EMAIL=test@case")
+		return cmd
+	}
+	return func() { newCommand = oldNewCommand }
+}
+
+func TestMergeQuiltPatch(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "test-merge-quilt-patch-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	repo := setupQuiltRepo(t, tempDir)
+
+	if format, err := sourceFormat(repo); err != nil || format != "3.0 (quilt)" {
+		t.Fatalf("sourceFormat: got (%q, %v), want %q", format, err, "3.0 (quilt)")
+	}
+
+	defer useRepo(repo)()
+
+	p := patch{
+		Author:  "Chris Lamb <lamby@debian.org>",
+		Subject: "min: please clarify README",
+		Data:    []byte(quiltTestPatch),
+		MsgNum:  5,
+		Date:    time.Date(2016, 7, 14, 17, 20, 12, 0, time.UTC),
+	}
+	if !p.touchesUpstream() {
+		t.Fatalf("touchesUpstream unexpectedly returned false")
+	}
+	if err := mergeQuiltPatch(repo, p, "Fix for “min: please clarify README” (Closes: #1)", "1"); err != nil {
+		t.Fatal(err)
+	}
+
+	readme, err := ioutil.ReadFile(filepath.Join(repo, "README"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(readme), "min is a minimal package\n"; got != want {
+		t.Fatalf("Upstream file was modified: got %q, want %q", got, want)
+	}
+	control, err := ioutil.ReadFile(filepath.Join(repo, "debian", "control"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(control), "Homepage: https://example.net/") {
+		t.Fatalf("debian/control was not modified: %q", control)
+	}
+	series, err := ioutil.ReadFile(filepath.Join(repo, "debian", "patches", "series"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(series), "please-clarify-readme.patch\n"; got != want {
+		t.Fatalf("Incorrect series file: got %q, want %q", got, want)
+	}
+	quiltPatch, err := ioutil.ReadFile(filepath.Join(repo, "debian", "patches", "please-clarify-readme.patch"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(quiltPatch), dep3Header(p, "1")+quiltTestPatch[:strings.Index(quiltTestPatch, "--- a/debian")]; got != want {
+		t.Fatalf("Incorrect quilt patch: got %q, want %q", got, want)
+	}
+	if got, want := gitIn(t, repo, "log", "-1", "--format=%an"), "Chris Lamb"; got != want {
+		t.Fatalf("Incorrect commit author: got %q, want %q", got, want)
+	}
+	if got := gitIn(t, repo, "status", "--porcelain"); got != "" {
+		t.Fatalf("Uncommitted changes left: %q", got)
+	}
+
+	// Merging the same patch again is detected, even though the
+	// quilt patch is not applied in the packaging repository.
+	applied, ok := checkQuiltApplied(repo, p).(*appliedError)
+	if !ok {
+		t.Fatalf("checkQuiltApplied did not detect the existing quilt patch")
+	}
+	if got, want := applied.QuiltPatch, "please-clarify-readme.patch"; got != want {
+		t.Fatalf("Incorrect quilt patch: got %q, want %q", got, want)
+	}
+	if got, want := applied.Commit, gitIn(t, repo, "rev-parse", "HEAD"); got != want {
+		t.Fatalf("Incorrect commit: got %q, want %q", got, want)
+	}
+
+	// A patch which conflicts with the existing quilt patch applies to
+	// the packaging repository, but not on top of the series.
+	conflicting := p
+	conflicting.Subject = "min: please shorten README"
+	conflicting.Data = []byte(`--- a/README
++++ b/README
+@@ -1 +1 @@
+-min is a minimal package
++min is a tiny package
+`)
+	if err := checkQuiltApplied(repo, conflicting); err != nil {
+		t.Fatalf("checkQuiltApplied: %v", err)
+	}
+	err = mergeQuiltPatch(repo, conflicting, "Fix for “min: please shorten README” (Closes: #2)", "2")
+	if err == nil || !strings.Contains(err.Error(), "debian/patches/please-shorten-readme.patch does not apply") {
+		t.Fatalf("mergeQuiltPatch: got %v, want an error about please-shorten-readme.patch", err)
+	}
+	if got, want := gitIn(t, repo, "log", "-1", "--format=%s"), "Fix for “min: please clarify README” (Closes: #1)"; got != want {
+		t.Fatalf("Conflicting patch was committed: got %q, want %q", got, want)
+	}
+
+	// A patch which only touches debian/ is not converted.
+	p.Data = []byte(quiltTestPatch[strings.Index(quiltTestPatch, "--- a/debian"):])
+	if p.touchesUpstream() {
+		t.Fatalf("touchesUpstream unexpectedly returned true for a debian/-only patch")
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Debian/mergebot/changelog"
+)
+
+// gitIdentity returns the “Name <email>” identity which git uses for
+// committing in the packaging repository, see gitCheckout.
+func gitIdentity() (string, error) {
+	var parts []string
+	for _, key := range []string{"user.name", "user.email"} {
+		output, err := newCommand("git", "config", key).Output()
+		if err != nil {
+			return "", fmt.Errorf("Could not determine git %s, please set DEBFULLNAME and DEBEMAIL: %v", key, err)
+		}
+		parts = append(parts, strings.TrimSpace(string(output)))
+	}
+	return fmt.Sprintf("%s <%s>", parts[0], parts[1]), nil
+}
+
+// changelogCommit is a commit which is listed in the changelog.
+type changelogCommit struct {
+	Author  string
+	Subject string
+
+	// Closes are the bugs which the commit message body closes, e.g.
+	// using a “Closes: #831331” trailer added by gitAm.
+	Closes []int
+}
+
+// Text returns the change for the changelog: the subject, followed by
+// the bugs closed in the body which the subject does not mention.
+func (c changelogCommit) Text() string {
+	closed := make(map[int]bool)
+	for _, bug := range changelog.ClosedBugs(c.Subject) {
+		closed[bug] = true
+	}
+	var refs []string
+	for _, bug := range c.Closes {
+		if !closed[bug] {
+			closed[bug] = true
+			refs = append(refs, fmt.Sprintf("#%d", bug))
+		}
+	}
+	if len(refs) == 0 {
+		return c.Subject
+	}
+	return fmt.Sprintf("%s (Closes: %s)", c.Subject, strings.Join(refs, ", "))
+}
+
+// commitsSince returns the commits after since (oldest first), except
+// for those which modify debian/changelog themselves, as their
+// author already described the change.
+func commitsSince(since string) ([]changelogCommit, error) {
+	// Commits are separated by a record separator, as their bodies
+	// span multiple lines.
+	output, err := newCommand("git", "log", "--reverse", "--no-merges", "--format=%x1e%H%x00%an%x00%s%x00%b", since+"..HEAD").Output()
+	if err != nil {
+		return nil, err
+	}
+	var commits []changelogCommit
+	for _, record := range strings.Split(string(output), "\x1e") {
+		fields := strings.Split(record, "\x00")
+		if len(fields) != 4 {
+			continue
+		}
+		files, err := newCommand("git", "diff-tree", "--no-commit-id", "--name-only", "-r", fields[0]).Output()
+		if err != nil {
+			return nil, err
+		}
+		if containsLine(string(files), "debian/changelog") {
+			continue
+		}
+		commits = append(commits, changelogCommit{
+			Author:  fields[1],
+			Subject: fields[2],
+			Closes:  changelog.ClosedBugs(fields[3]),
+		})
+	}
+	return commits, nil
+}
+
+func containsLine(text, line string) bool {
+	for _, l := range strings.Split(text, "\n") {
+		if l == line {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPendingEntry verifies that the UNRELEASED entry e, which is
+// released instead of adding a new entry, matches -upload_type: its
+// version must follow previous (the version of the preceding entry, if
+// any), and it must contain the upload’s boilerplate.
+func checkPendingEntry(e *changelog.Entry, previous string, upload uploadType, codename string) error {
+	if *uploadTypeName == "maintainer" {
+		return nil
+	}
+	var problems []string
+	if previous != "" {
+		want, err := upload.Version(previous, codename)
+		if err != nil {
+			return err
+		}
+		if e.Version != want {
+			problems = append(problems, fmt.Sprintf("version %s instead of %s", e.Version, want))
+		}
+	}
+	if want := upload.Boilerplate(codename); want != "" {
+		found := false
+		for _, section := range e.Sections {
+			for _, bullet := range section.Bullets {
+				found = found || bullet.Text() == want
+			}
+		}
+		if !found {
+			problems = append(problems, fmt.Sprintf("no “%s” change", want))
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("The UNRELEASED entry %s does not match -upload_type=%s (%s), please adjust it before merging", e.Version, *uploadTypeName, strings.Join(problems, ", "))
+	}
+	return nil
+}
+
+// releaseChangelog adds a change for every commit after since to the
+// changelog (grouped by author), releases the resulting entry and
+// commits it, like gbp dch --release --git-author --commit does. An
+// UNRELEASED entry (e.g. containing the maintainer’s pending changes)
+// is released (provided it matches -upload_type, see
+// checkPendingEntry), otherwise a new entry (whose version and
+// boilerplate are determined by -upload_type) is added. The entry is
+// released into suite.
+func releaseChangelog(checkoutDir, since, suite string) error {
+	upload, err := lookupUploadType(*uploadTypeName)
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(checkoutDir, "debian", "changelog")
+	c, err := changelog.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if len(c.Entries) == 0 {
+		return fmt.Errorf("%q does not contain any entries", path)
+	}
+
+	maintainer, err := gitIdentity()
+	if err != nil {
+		return err
+	}
+
+	commits, err := commitsSince(since)
+	if err != nil {
+		return err
+	}
+
+	var codename string
+	if upload.Stable() {
+		if codename, err = suiteCodename(suite); err != nil {
+			return err
+		}
+	}
+
+	entry := c.Entries[0]
+	if !entry.Released() {
+		var previous string
+		if len(c.Entries) > 1 {
+			previous = c.Entries[1].Version
+		}
+		if err := checkPendingEntry(entry, previous, upload, codename); err != nil {
+			return err
+		}
+	} else {
+		version, err := upload.Version(entry.Version, codename)
+		if err != nil {
+			return err
+		}
+		entry = &changelog.Entry{
+			Source:        entry.Source,
+			Version:       version,
+			Distributions: []string{changelog.Unreleased},
+			Urgency:       "medium",
+			Maintainer:    maintainer,
+		}
+		if text := upload.Boilerplate(codename); text != "" {
+			entry.AddBullet("", text)
+		}
+		c.AddEntry(entry)
+	}
+	for _, commit := range commits {
+		entry.AddBullet(commit.Author, commit.Text())
+	}
+	if err := entry.Finalize(maintainer, suite, time.Now()); err != nil {
+		return err
+	}
+	if err := c.WriteFile(path); err != nil {
+		return err
+	}
+	log.Printf("Released version %q with %d changes", entry.Version, len(commits))
+
+	return newCommand("git", "commit",
+		"--message", fmt.Sprintf("Update changelog for %s release", entry.Version),
+		"--", path).Run()
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestReleaseChangelog(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "test-release-changelog-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	repo := setupQuiltRepo(t, tempDir)
+	defer useRepo(repo)()
+	gitIn(t, repo, "config", "user.name", "Test Case")
+	gitIn(t, repo, "config", "user.email", "test@case")
+	since := gitIn(t, repo, "rev-parse", "HEAD")
+
+	for _, subject := range []string{"Fix for “wit: FTBFS” (Closes: #1)", "Fix for “wit: please make the build reproducible” (Closes: #2)"} {
+		if err := ioutil.WriteFile(filepath.Join(repo, "README"), []byte(subject+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		gitIn(t, repo, "commit", "-a", "-m", subject)
+	}
+
+	if err := releaseChangelog(repo, since, "unstable"); err != nil {
+		t.Fatal(err)
+	}
+
+	contents, err := ioutil.ReadFile(filepath.Join(repo, "debian", "changelog"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := regexp.MustCompile(`^min \(1\.1\) unstable; urgency=medium
+
+  \[ Chris Lamb \]
+  \* Fix for “wit: FTBFS” \(Closes: #1\)
+  \* Fix for “wit: please make the build reproducible” \(Closes: #2\)
+
+ -- Test Case <test@case>  \w{3}, \d{2} \w{3} \d{4} \d{2}:\d{2}:\d{2} [-+]\d{4}
+
+min \(1\.0\) unstable; urgency=low
+`)
+	if !want.Match(contents) {
+		t.Fatalf("Unexpected changelog: got %q, want match for %q", contents, want)
+	}
+
+	if got, want := gitIn(t, repo, "log", "-1", "--format=%an: %s"), "Test Case: Update changelog for 1.1 release"; got != want {
+		t.Fatalf("Unexpected commit: got %q, want %q", got, want)
+	}
+}
+
+func TestReleaseChangelogGitAm(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "test-release-changelog-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	repo := setupQuiltRepo(t, tempDir)
+	defer useRepo(repo)()
+	gitIn(t, repo, "config", "user.name", "Test Case")
+	gitIn(t, repo, "config", "user.email", "test@case")
+	since := gitIn(t, repo, "rev-parse", "HEAD")
+
+	// Create a git format-patch submission without a Closes trailer.
+	if err := ioutil.WriteFile(filepath.Join(repo, "README"), []byte("Standards-Version: 3.9.7\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	gitIn(t, repo, "commit", "-a", "-m", "Declare Standards-Version 3.9.7")
+	formatPatch := gitIn(t, repo, "format-patch", "--stdout", "-1")
+	gitIn(t, repo, "reset", "--hard", since)
+	if err := ioutil.WriteFile(filepath.Join(tempDir, patchFileName), []byte(formatPatch+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := gitAm("1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := releaseChangelog(repo, since, "unstable"); err != nil {
+		t.Fatal(err)
+	}
+
+	contents, err := ioutil.ReadFile(filepath.Join(repo, "debian", "changelog"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "  * Declare Standards-Version 3.9.7 (Closes: #1)\n"; !strings.Contains(string(contents), want) {
+		t.Fatalf("Unexpected changelog: got %q, want it to contain %q", contents, want)
+	}
+}
+
+func TestChangelogCommitText(t *testing.T) {
+	for _, tt := range []struct {
+		commit changelogCommit
+		want   string
+	}{
+		{changelogCommit{Subject: "Declare Standards-Version 3.9.7"}, "Declare Standards-Version 3.9.7"},
+		{changelogCommit{Subject: "Declare Standards-Version 3.9.7", Closes: []int{1, 2}}, "Declare Standards-Version 3.9.7 (Closes: #1, #2)"},
+		{changelogCommit{Subject: "Fix for “wit: FTBFS” (Closes: #1)", Closes: []int{1}}, "Fix for “wit: FTBFS” (Closes: #1)"},
+	} {
+		if got := tt.commit.Text(); got != tt.want {
+			t.Errorf("Text(%+v): got %q, want %q", tt.commit, got, tt.want)
+		}
+	}
+}
+
+func TestReleaseChangelogNMU(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "test-release-changelog-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	repo := setupQuiltRepo(t, tempDir)
+	defer useRepo(repo)()
+	gitIn(t, repo, "config", "user.name", "Test Case")
+	gitIn(t, repo, "config", "user.email", "test@case")
+	since := gitIn(t, repo, "rev-parse", "HEAD")
+	if err := ioutil.WriteFile(filepath.Join(repo, "README"), []byte("fixed\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	gitIn(t, repo, "commit", "-a", "-m", "Fix for “wit: FTBFS” (Closes: #1)")
+
+	oldUploadType := *uploadTypeName
+	defer func() { *uploadTypeName = oldUploadType }()
+	*uploadTypeName = "nmu"
+
+	if err := releaseChangelog(repo, since, "unstable"); err != nil {
+		t.Fatal(err)
+	}
+
+	contents, err := ioutil.ReadFile(filepath.Join(repo, "debian", "changelog"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `min (1.0+nmu1) unstable; urgency=medium
+
+  [ Test Case ]
+  * Non-maintainer upload.
+
+  [ Chris Lamb ]
+  * Fix for “wit: FTBFS” (Closes: #1)
+
+ -- Test Case <test@case>  `
+	if !strings.HasPrefix(string(contents), want) {
+		t.Fatalf("Unexpected changelog: got %q, want prefix %q", contents, want)
+	}
+
+	// A pending UNRELEASED entry is only released if it matches the
+	// upload type.
+	since = gitIn(t, repo, "rev-parse", "HEAD")
+	for _, tt := range []struct {
+		pending string
+		wantErr bool
+	}{
+		{"min (1.1) UNRELEASED; urgency=medium\n\n  * Fix the build.\n", true},
+		{"min (1.0+nmu2) UNRELEASED; urgency=medium\n\n  * Fix the build.\n", true},
+		{"min (1.0+nmu2) UNRELEASED; urgency=medium\n\n  * Non-maintainer upload.\n  * Fix the build.\n", false},
+	} {
+		pending := tt.pending + "\n -- Test Case <test@case>  Mon, 01 Aug 2016 10:00:00 +0200\n\n"
+		if err := ioutil.WriteFile(filepath.Join(repo, "debian", "changelog"), append([]byte(pending), contents...), 0644); err != nil {
+			t.Fatal(err)
+		}
+		err := releaseChangelog(repo, since, "unstable")
+		if gotErr := err != nil; gotErr != tt.wantErr {
+			t.Fatalf("releaseChangelog with pending entry %q: got %v, want error: %v", tt.pending, err, tt.wantErr)
+		}
+	}
+	if got, want := gitIn(t, repo, "log", "-1", "--format=%s"), "Update changelog for 1.0+nmu2 release"; got != want {
+		t.Fatalf("Unexpected commit: got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	keyrings = flag.String("keyring",
+		"/usr/share/keyrings/debian-keyring.gpg",
+		"Comma-separated list of OpenPGP keyrings. PGP/MIME signed patch submissions are verified against the keys in these keyrings.")
+
+	requireSignature = flag.Bool("require_signature",
+		false,
+		"Refuse to merge patches which are not PGP/MIME signed by a key contained in -keyring.")
+)
+
+// trustLevel describes the outcome of verifying the PGP/MIME signature
+// of the message in which a patch was found.
+type trustLevel int
+
+const (
+	// trustUnsigned means the message was not PGP/MIME signed.
+	trustUnsigned trustLevel = iota
+
+	// trustBad means the signature does not match the message, i.e.
+	// the message was modified after signing.
+	trustBad
+
+	// trustUnknownKey means the signing key is not contained in any
+	// of the configured keyrings.
+	trustUnknownKey
+
+	// trustExpired means the signing key has expired or was revoked.
+	trustExpired
+
+	// trustValid means the signature is good and was made by a key
+	// contained in one of the configured keyrings.
+	trustValid
+
+	// trustError means the signature could not be verified at all,
+	// e.g. because it is malformed or gpgv failed to run.
+	trustError
+)
+
+func (t trustLevel) String() string {
+	switch t {
+	case trustUnsigned:
+		return "unsigned"
+	case trustBad:
+		return "bad signature"
+	case trustUnknownKey:
+		return "unknown key"
+	case trustExpired:
+		return "expired or revoked key"
+	case trustValid:
+		return "valid"
+	case trustError:
+		return "verification failed"
+	}
+	return fmt.Sprintf("trustLevel(%d)", int(t))
+}
+
+// signature is the result of verifying a PGP/MIME signature.
+type signature struct {
+	// Signer is the fingerprint of the signing key. In case the key
+	// is not contained in the keyrings, only its key id is known.
+	Signer string
+	Trust  trustLevel
+
+	// Err is set if Trust is trustError.
+	Err error
+}
+
+// splitSigned splits the raw body of a multipart/signed entity into
+// the signed part (including its MIME header) and the detached
+// signature. As per RFC 3156, section 5, the signed part is returned
+// with CRLF line endings, which is the form in which it was signed.
+func splitSigned(body []byte, boundary string) (signed []byte, sig []byte, err error) {
+	// Debbugs stores messages with LF line endings, but be liberal in
+	// what we accept.
+	text := bytes.Replace(body, []byte("\r\n"), []byte("\n"), -1)
+	delimiter := []byte("\n--" + boundary)
+
+	// The preamble might be empty, so that the first delimiter is at
+	// the very beginning of the body.
+	text = append([]byte{'\n'}, text...)
+	start := bytes.Index(text, delimiter)
+	if start == -1 {
+		return nil, nil, fmt.Errorf("multipart/signed: boundary %q not found", boundary)
+	}
+	text = text[start+len(delimiter):]
+	// Skip the remainder of the delimiter line (transport padding).
+	if idx := bytes.IndexByte(text, '\n'); idx > -1 {
+		text = text[idx+1:]
+	}
+	end := bytes.Index(text, delimiter)
+	if end == -1 {
+		return nil, nil, fmt.Errorf("multipart/signed: signed part not terminated")
+	}
+	signed = bytes.Replace(text[:end], []byte("\n"), []byte("\r\n"), -1)
+
+	mr := multipart.NewReader(bytes.NewReader(body), boundary)
+	for i := 0; i < 2; i++ {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			return nil, nil, fmt.Errorf("multipart/signed: signature part missing")
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if i == 0 {
+			continue
+		}
+		if mediaType, _, _ := mime.ParseMediaType(p.Header.Get("Content-Type")); mediaType != "application/pgp-signature" {
+			return nil, nil, fmt.Errorf("multipart/signed: unexpected signature Content-Type: got %q, want %q", mediaType, "application/pgp-signature")
+		}
+		sig, err = decodeTransferEncoding(p.Header.Get("Content-Transfer-Encoding"), p)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return signed, sig, nil
+}
+
+// parseGpgvStatus interprets the machine-readable output of gpgv
+// --status-fd (see doc/DETAILS in the GnuPG source).
+func parseGpgvStatus(status []byte) signature {
+	result := signature{Trust: trustBad}
+	scanner := bufio.NewScanner(bytes.NewReader(status))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 || fields[0] != "[GNUPG:]" {
+			continue
+		}
+		switch fields[1] {
+		case "VALIDSIG":
+			// The primary key fingerprint is the last field, the
+			// first one might be the fingerprint of a subkey.
+			result.Signer = fields[len(fields)-1]
+		case "GOODSIG":
+			if result.Trust == trustBad {
+				result.Trust = trustValid
+			}
+		case "EXPKEYSIG", "REVKEYSIG":
+			result.Trust = trustExpired
+		case "BADSIG":
+			result.Trust = trustBad
+			result.Signer = fields[2]
+			return result
+		case "NO_PUBKEY":
+			result.Trust = trustUnknownKey
+			result.Signer = fields[2]
+		}
+	}
+	if result.Trust == trustValid && result.Signer == "" {
+		// GOODSIG without VALIDSIG should not happen, but do not
+		// consider a signature valid without knowing its signer.
+		result.Trust = trustBad
+	}
+	return result
+}
+
+// verifySignature verifies the detached signature sig of signed using
+// gpgv(1) and the keyrings specified in -keyring.
+func verifySignature(signed, sig []byte) (signature, error) {
+	dir, err := ioutil.TempDir("", "mergebot-signature-")
+	if err != nil {
+		return signature{}, err
+	}
+	defer os.RemoveAll(dir)
+	signedPath := filepath.Join(dir, "signed")
+	sigPath := filepath.Join(dir, "signature.asc")
+	if err := ioutil.WriteFile(signedPath, signed, 0600); err != nil {
+		return signature{}, err
+	}
+	if err := ioutil.WriteFile(sigPath, sig, 0600); err != nil {
+		return signature{}, err
+	}
+
+	args := []string{"--status-fd", "1"}
+	for _, keyring := range splitList(*keyrings) {
+		// gpgv looks up relative keyring names in ~/.gnupg.
+		abs, err := filepath.Abs(keyring)
+		if err != nil {
+			return signature{}, err
+		}
+		args = append(args, "--keyring", abs)
+	}
+	args = append(args, sigPath, signedPath)
+
+	// gpgv exits non-zero for bad signatures and unknown keys, both of
+	// which are reported on the status file descriptor.
+	status, err := newCommand("gpgv", args...).Output()
+	if len(status) == 0 && err != nil {
+		return signature{}, fmt.Errorf("gpgv: %v", err)
+	}
+	result := parseGpgvStatus(status)
+	if result.Trust != trustValid {
+		log.Printf("Signature verification failed: %v (signer %q)", result.Trust, result.Signer)
+	}
+	return result, nil
+}
+
+// checkSignaturePolicy returns an error if -require_signature is
+// specified and any patch of series was not signed by a key contained
+// in -keyring.
+func checkSignaturePolicy(series []patch) error {
+	if !*requireSignature {
+		return nil
+	}
+	for _, p := range series {
+		if p.SigErr != nil {
+			return fmt.Errorf("Refusing to merge patch %q from message #%d: signature: %v, but -require_signature is set", p.Filename, p.MsgNum, p.SigErr)
+		}
+		if p.Trust != trustValid {
+			return fmt.Errorf("Refusing to merge patch %q from message #%d: signature: %v (signer %q), but -require_signature is set", p.Filename, p.MsgNum, p.Trust, p.Signer)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/Debian/mergebot/debbugs"
+	"github.com/Debian/mergebot/debbugs/debbugstest"
+)
+
+// testdata/signing-key.{pub,sec} is a throwaway key which was used to
+// sign testdata/831331-signed.soap. As the key is only used for tests,
+// it being public is not an issue.
+const (
+	signingKeyring     = "testdata/signing-key.pub"
+	signingFingerprint = "FE4F863326C0E88D93ABC4FB5308E75B96A84961"
+)
+
+func getSignedPatch(t *testing.T, soapPath, keyring string) patch {
+	srv := debbugstest.NewServer()
+	defer srv.Close()
+	srv.Recordings["get_bug_log"] = soapPath
+	client := debbugs.NewClient(srv.URL)
+
+	oldKeyrings := *keyrings
+	defer func() { *keyrings = oldKeyrings }()
+	*keyrings = keyring
+
+	series, err := getPatchSeries(client, 831331, 0, "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return series[0]
+}
+
+func TestSignedPatch(t *testing.T) {
+	p := getSignedPatch(t, "testdata/831331-signed.soap", signingKeyring)
+
+	if got, want := p.Trust, trustValid; got != want {
+		t.Fatalf("Incorrect trust level: got %v, want %v", got, want)
+	}
+	if got, want := p.Signer, signingFingerprint; got != want {
+		t.Fatalf("Incorrect signer: got %q, want %q", got, want)
+	}
+	if got, want := p.Filename, "wit.diff.txt"; got != want {
+		t.Fatalf("Incorrect attachment: got %q, want %q", got, want)
+	}
+	goldenPatch, err := ioutil.ReadFile(goldenPatchPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(p.Data, goldenPatch) {
+		t.Fatalf("Signed patch data does not match %q", goldenPatchPath)
+	}
+}
+
+func TestSignedPatchUnknownKey(t *testing.T) {
+	// The sbuild key certainly did not sign any patches.
+	p := getSignedPatch(t, "testdata/831331-signed.soap", "travis/sbuild-key.pub")
+
+	if got, want := p.Trust, trustUnknownKey; got != want {
+		t.Fatalf("Incorrect trust level: got %v, want %v", got, want)
+	}
+	if got, want := p.Signer, signingFingerprint[24:]; got != want {
+		t.Fatalf("Incorrect signer key id: got %q, want %q", got, want)
+	}
+}
+
+func TestSignedPatchTampered(t *testing.T) {
+	p := getSignedPatch(t, "testdata/831331-signed-tampered.soap", signingKeyring)
+
+	if got, want := p.Trust, trustBad; got != want {
+		t.Fatalf("Incorrect trust level: got %v, want %v", got, want)
+	}
+}
+
+func TestUnsignedPatch(t *testing.T) {
+	p := getSignedPatch(t, goldenSoapPath, signingKeyring)
+
+	if got, want := p.Trust, trustUnsigned; got != want {
+		t.Fatalf("Incorrect trust level: got %v, want %v", got, want)
+	}
+	if got, want := p.Signer, ""; got != want {
+		t.Fatalf("Incorrect signer: got %q, want %q", got, want)
+	}
+}
+
+func TestCheckSignaturePolicy(t *testing.T) {
+	oldRequireSignature := *requireSignature
+	defer func() { *requireSignature = oldRequireSignature }()
+
+	series := []patch{
+		{Filename: "0001-a.patch", Trust: trustValid, Signer: signingFingerprint},
+		{Filename: "0002-b.patch", Trust: trustUnknownKey, Signer: "5308E75B96A84961"},
+	}
+
+	*requireSignature = false
+	if err := checkSignaturePolicy(series); err != nil {
+		t.Fatalf("Unexpected error without -require_signature: %v", err)
+	}
+
+	*requireSignature = true
+	if err := checkSignaturePolicy(series[:1]); err != nil {
+		t.Fatalf("Unexpected error for a validly signed patch: %v", err)
+	}
+	if err := checkSignaturePolicy(series); err == nil {
+		t.Fatalf("checkSignaturePolicy unexpectedly accepted a patch signed by an unknown key")
+	}
+	if err := checkSignaturePolicy([]patch{{Filename: "wit.diff.txt"}}); err == nil {
+		t.Fatalf("checkSignaturePolicy unexpectedly accepted an unsigned patch")
+	}
+}
+
+// signedItem returns a bug log item whose body is a multipart/signed
+// entity of protocol, covering a message with a single attachment.
+func signedItem(protocol, signaturePart string) debbugs.BugLogItem {
+	const diff = "--- a/README\n+++ b/README\n@@ -1 +1 @@\n-min\n+max\n"
+	return debbugs.BugLogItem{
+		MsgNum: 5,
+		Header: "From: Chris Lamb <lamby@debian.org>\nSubject: wit: FTBFS\nMIME-Version: 1.0\nContent-Type: multipart/signed; protocol=\"" + protocol + "\"; micalg=sha-256; boundary=\"s\"\n",
+		Body: "--s\nContent-Type: multipart/mixed; boundary=\"b\"\n\n" +
+			"--b\nContent-Type: text/plain\n\nSee attachment.\n" +
+			"--b\nContent-Type: text/x-diff\nContent-Disposition: attachment; filename=\"fix.diff\"\n\n" + diff + "\n--b--\n" +
+			signaturePart + "--s--\n",
+	}
+}
+
+func TestSignedPatchUnverifiable(t *testing.T) {
+	oldRequireSignature := *requireSignature
+	defer func() { *requireSignature = oldRequireSignature }()
+
+	for _, tt := range []struct {
+		desc string
+		item debbugs.BugLogItem
+		want trustLevel
+	}{
+		{
+			desc: "S/MIME",
+			item: signedItem("application/pkcs7-signature", "--s\nContent-Type: application/pkcs7-signature; name=\"smime.p7s\"\nContent-Disposition: attachment; filename=\"smime.p7s\"\nContent-Transfer-Encoding: base64\n\nMIAGCSqGSIb3DQEHAqCAMIACAQExDzANBglghkgBZQMEAgEFADCABgkqhkiG9w0BBwEAAA==\n"),
+			want: trustUnsigned,
+		},
+		{
+			desc: "PGP/MIME without signature",
+			item: signedItem("application/pgp-signature", ""),
+			want: trustError,
+		},
+	} {
+		patches, err := patchesFromMessage(tt.item)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.desc, err)
+		}
+		if got, want := len(patches), 1; got != want {
+			t.Fatalf("%s: Unexpected number of patches: got %d, want %d", tt.desc, got, want)
+		}
+		p := patches[0]
+		if got, want := p.Filename, "fix.diff"; got != want {
+			t.Fatalf("%s: Incorrect attachment: got %q, want %q", tt.desc, got, want)
+		}
+		if got, want := p.Trust, tt.want; got != want {
+			t.Fatalf("%s: Incorrect trust level: got %v, want %v", tt.desc, got, want)
+		}
+
+		*requireSignature = false
+		if err := checkSignaturePolicy(patches); err != nil {
+			t.Fatalf("%s: Unexpected error without -require_signature: %v", tt.desc, err)
+		}
+		*requireSignature = true
+		if err := checkSignaturePolicy(patches); err == nil {
+			t.Fatalf("%s: checkSignaturePolicy unexpectedly accepted the patch", tt.desc)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"strings"
+)
+
+var targetSuite = flag.String("target_suite",
+	"",
+	"Suite into which the package will be uploaded, e.g. unstable, experimental, bookworm-backports or bookworm (for stable updates). Used as changelog distribution and for selecting the sbuild chroot. Defaults to the suite of -upload_type.")
+
+// suiteSuffixes are the suffixes of suites which are based on a stable
+// release, e.g. “bookworm-backports”.
+var suiteSuffixes = []string{"-backports", "-proposed-updates", "-security"}
+
+// resolveTargetSuite returns the suite specified in -target_suite, or
+// the default suite of upload if -target_suite is empty.
+func resolveTargetSuite(upload uploadType) (string, error) {
+	if *targetSuite != "" {
+		return *targetSuite, nil
+	}
+	if !upload.Stable() {
+		return upload.Distribution(""), nil
+	}
+	codename, err := stableCodename()
+	if err != nil {
+		return "", err
+	}
+	return upload.Distribution(codename), nil
+}
+
+// baseSuite returns the suite on which suite is based, i.e. the suite
+// whose chroot is used for building, e.g. “unstable” for “experimental”
+// and “bookworm” for “bookworm-backports”.
+func baseSuite(suite string) string {
+	switch suite {
+	case "experimental", "sid", "UNRELEASED":
+		return "unstable"
+	}
+	for _, suffix := range suiteSuffixes {
+		if strings.HasSuffix(suite, suffix) {
+			return strings.TrimSuffix(suite, suffix)
+		}
+	}
+	return suite
+}
+
+// suiteCodename returns the code name of the release on which suite is
+// based (as required for backport and stable update version suffixes),
+// or an error if suite is not based on a numbered release.
+func suiteCodename(suite string) (string, error) {
+	base := baseSuite(suite)
+	if _, err := releaseNumber(base); err != nil {
+		return "", err
+	}
+	return base, nil
+}
+
+// checkTargetSuite verifies that suite is suitable for upload, e.g.
+// that backports target a -backports suite.
+func checkTargetSuite(suite string, upload uploadType) error {
+	if !upload.Stable() {
+		return nil
+	}
+	if _, err := suiteCodename(suite); err != nil {
+		return fmt.Errorf("-upload_type=%s requires a -target_suite based on a stable release, but got %q: %v", *uploadTypeName, suite, err)
+	}
+	if want := upload.Distribution(baseSuite(suite)); *uploadTypeName == "bpo" && suite != want {
+		return fmt.Errorf("-upload_type=bpo requires a -backports -target_suite, e.g. %q, but got %q", want, suite)
+	}
+	return nil
+}
+
+// sbuildChroot returns the name of the sbuild chroot for building
+// packages for suite, as created by sbuild-createchroot, e.g.
+// “unstable-amd64-sbuild”.
+func sbuildChroot(suite string) (string, error) {
+	output, err := newCommand("dpkg", "--print-architecture").Output()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s-%s-sbuild", baseSuite(suite), strings.TrimSpace(string(output))), nil
+}
+
+// checkChroot verifies that the specified schroot chroot exists, so
+// that the build does not fail after cloning and merging.
+func checkChroot(chroot string) error {
+	output, err := newCommand("schroot", "--list", "--all").Output()
+	if err != nil {
+		return err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		switch scanner.Text() {
+		case "chroot:" + chroot, "source:" + chroot:
+			return nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return fmt.Errorf("sbuild chroot %q not found, create it using sbuild-createchroot", chroot)
+}
+
+// sbuildArgs returns the arguments for building a package for suite in
+// chroot using sbuild. Suites whose packages are not contained in the
+// chroot (e.g. experimental) are added as extra repositories.
+func sbuildArgs(suite, chroot string) []string {
+	args := []string{"-v", "-As", "--dist=" + suite, "--chroot=" + chroot}
+	var extra string
+	switch {
+	case suite == "experimental":
+		extra = "experimental"
+		// Prefer the versions in experimental over those in unstable.
+		args = append(args, "--build-dep-resolver=aptitude")
+	case strings.HasSuffix(suite, "-backports"), strings.HasSuffix(suite, "-proposed-updates"):
+		extra = suite
+	}
+	if extra != "" {
+		args = append(args, fmt.Sprintf("--extra-repository=deb http://deb.debian.org/debian %s main", extra))
+	}
+	return args
+}
+
+// dputCommand returns the command for uploading packages built for
+// suite.
+func dputCommand(suite string) string {
+	if strings.HasSuffix(suite, "-security") {
+		return "dput security-master *.changes"
+	}
+	return "dput ftp-master *.changes"
+}
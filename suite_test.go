@@ -0,0 +1,116 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSuites(t *testing.T) {
+	defer useDistroInfo(t)()
+
+	for _, tt := range []struct {
+		suite    string
+		base     string
+		codename string
+		dput     string
+	}{
+		// Suites which are not based on a release have no code name.
+		{"unstable", "unstable", "", "dput ftp-master *.changes"},
+		{"experimental", "unstable", "", "dput ftp-master *.changes"},
+		{"bookworm-backports", "bookworm", "bookworm", "dput ftp-master *.changes"},
+		{"bookworm", "bookworm", "bookworm", "dput ftp-master *.changes"},
+		{"trixie-security", "trixie", "trixie", "dput security-master *.changes"},
+	} {
+		if got := baseSuite(tt.suite); got != tt.base {
+			t.Errorf("baseSuite(%q): got %q, want %q", tt.suite, got, tt.base)
+		}
+		if got, err := suiteCodename(tt.suite); got != tt.codename || (err != nil) != (tt.codename == "") {
+			t.Errorf("suiteCodename(%q): got %q (err %v), want %q", tt.suite, got, err, tt.codename)
+		}
+		if got := dputCommand(tt.suite); got != tt.dput {
+			t.Errorf("dputCommand(%q): got %q, want %q", tt.suite, got, tt.dput)
+		}
+	}
+}
+
+func TestCheckTargetSuite(t *testing.T) {
+	defer useDistroInfo(t)()
+	oldUploadType, oldTargetSuite := *uploadTypeName, *targetSuite
+	defer func() { *uploadTypeName, *targetSuite = oldUploadType, oldTargetSuite }()
+
+	for _, tt := range []struct {
+		uploadType  string
+		targetSuite string
+		want        string
+		wantErr     bool
+	}{
+		{"maintainer", "", "unstable", false},
+		{"maintainer", "experimental", "experimental", false},
+		{"bpo", "", "trixie-backports", false},
+		{"bpo", "bookworm-backports", "bookworm-backports", false},
+		{"bpo", "bookworm", "bookworm", true},
+		{"bpo", "unstable", "unstable", true},
+		{"bpo", "trixy-backports", "trixy-backports", true},
+		{"stable-update", "", "trixie", false},
+		{"stable-update", "experimental", "experimental", true},
+	} {
+		*uploadTypeName, *targetSuite = tt.uploadType, tt.targetSuite
+		upload, err := lookupUploadType(tt.uploadType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		suite, err := resolveTargetSuite(upload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if suite != tt.want {
+			t.Errorf("%s upload with -target_suite=%q: got suite %q, want %q", tt.uploadType, tt.targetSuite, suite, tt.want)
+		}
+		if err := checkTargetSuite(suite, upload); (err != nil) != tt.wantErr {
+			t.Errorf("%s upload to %q: got error %v, want error: %v", tt.uploadType, suite, err, tt.wantErr)
+		}
+	}
+}
+
+func TestSbuildArgs(t *testing.T) {
+	for _, tt := range []struct {
+		suite string
+		want  []string
+	}{
+		{"unstable", []string{"-v", "-As", "--dist=unstable", "--chroot=unstable-amd64-sbuild"}},
+		{"experimental", []string{"-v", "-As", "--dist=experimental", "--chroot=unstable-amd64-sbuild", "--build-dep-resolver=aptitude", "--extra-repository=deb http://deb.debian.org/debian experimental main"}},
+		{"bookworm-backports", []string{"-v", "-As", "--dist=bookworm-backports", "--chroot=bookworm-amd64-sbuild", "--extra-repository=deb http://deb.debian.org/debian bookworm-backports main"}},
+	} {
+		chroot := baseSuite(tt.suite) + "-amd64-sbuild"
+		if got := sbuildArgs(tt.suite, chroot); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("sbuildArgs(%q, %q): got %q, want %q", tt.suite, chroot, got, tt.want)
+		}
+	}
+}
+
+func TestCheckChroot(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "test-check-chroot-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// Divert schroot with a shell script.
+	schroot := "#!/bin/sh\necho chroot:unstable-amd64-sbuild\necho source:unstable-amd64-sbuild\n"
+	if err := ioutil.WriteFile(filepath.Join(tempDir, "schroot"), []byte(schroot), 0755); err != nil {
+		t.Fatal(err)
+	}
+	oldPath := os.Getenv("PATH")
+	defer os.Setenv("PATH", oldPath)
+	os.Setenv("PATH", tempDir+":"+oldPath)
+
+	if err := checkChroot("unstable-amd64-sbuild"); err != nil {
+		t.Fatalf("checkChroot: %v", err)
+	}
+	if err := checkChroot("bookworm-amd64-sbuild"); err == nil {
+		t.Fatalf("checkChroot unexpectedly succeeded for a missing chroot")
+	}
+}
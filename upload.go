@@ -0,0 +1,258 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var uploadTypeName = flag.String("upload_type",
+	"maintainer",
+	"Type of the upload, which determines the version, distribution and boilerplate of the changelog entry. One of maintainer, team, nmu, qa, bpo (backport to stable) and stable-update.")
+
+// stableCodename returns the code name of the current stable release
+// (which backports and stable updates target by default) according to
+// distro-info.
+func stableCodename() (string, error) {
+	output, err := newCommand("debian-distro-info", "--stable").Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// releaseNumber returns the version number of the Debian release
+// codename according to distro-info, as used in version suffixes, e.g.
+// “13” for “~bpo13+1”. Unknown code names and suites without version
+// number (e.g. sid) result in an error.
+func releaseNumber(codename string) (string, error) {
+	output, err := newCommand("debian-distro-info", "--series="+codename, "--release").Output()
+	if err != nil {
+		return "", fmt.Errorf("Unknown Debian release %q: %v", codename, err)
+	}
+	number := strings.TrimSpace(string(output))
+	if number == "" {
+		return "", fmt.Errorf("Debian release %q has no version number", codename)
+	}
+	return number, nil
+}
+
+// uploadType describes how the changelog entry of a particular kind of
+// upload looks, see
+// https://www.debian.org/doc/manuals/developers-reference/pkgs.html
+type uploadType interface {
+	// Boilerplate returns the first change of the entry (e.g.
+	// “Non-maintainer upload.”), given the code name of the stable
+	// release, or "" for uploads without boilerplate.
+	Boilerplate(codename string) string
+
+	// Version returns the version of the upload, given the version of
+	// the previous changelog entry and the code name of the stable
+	// release.
+	Version(previous, codename string) (string, error)
+
+	// Distribution returns the distribution into which the upload
+	// goes, given the code name of the stable release.
+	Distribution(codename string) string
+
+	// Stable returns whether the upload is based on a stable release,
+	// i.e. whether it needs its code name. For all other uploads, the
+	// methods above are passed an empty code name.
+	Stable() bool
+}
+
+var uploadTypes = map[string]uploadType{
+	"maintainer":    unstableUpload{nextVersion: nextVersion},
+	"team":          unstableUpload{boilerplate: "Team upload.", nextVersion: nextVersion},
+	"nmu":           unstableUpload{boilerplate: "Non-maintainer upload.", nextVersion: nmuVersion},
+	"qa":            unstableUpload{boilerplate: "QA upload.", nextVersion: nextVersion},
+	"bpo":           backportUpload{},
+	"stable-update": stableUpdate{},
+}
+
+// lookupUploadType returns the upload type called name.
+func lookupUploadType(name string) (uploadType, error) {
+	t, err := lookupChoice("-upload_type", uploadTypes, name)
+	if err != nil {
+		return nil, err
+	}
+	return t.(uploadType), nil
+}
+
+// unstableUpload is an upload to unstable, e.g. by the maintainer.
+type unstableUpload struct {
+	// boilerplate is e.g. “Team upload.”, or empty.
+	boilerplate string
+
+	// nextVersion returns the version following the previous one.
+	nextVersion func(previous string) string
+}
+
+func (u unstableUpload) Boilerplate(codename string) string {
+	return u.boilerplate
+}
+
+func (u unstableUpload) Version(previous, codename string) (string, error) {
+	return u.nextVersion(previous), nil
+}
+
+func (unstableUpload) Distribution(codename string) string {
+	return "unstable"
+}
+
+func (unstableUpload) Stable() bool {
+	return false
+}
+
+// backportUpload is a backport to the stable release, see
+// https://backports.debian.org/Contribute/
+type backportUpload struct{}
+
+func (backportUpload) Boilerplate(codename string) string {
+	return "Rebuild for " + codename + "-backports."
+}
+
+func (backportUpload) Version(previous, codename string) (string, error) {
+	return backportVersion(previous, codename)
+}
+
+func (backportUpload) Distribution(codename string) string {
+	return codename + "-backports"
+}
+
+func (backportUpload) Stable() bool {
+	return true
+}
+
+// stableUpdate is an update of the package in the stable release, e.g.
+// via a point release.
+type stableUpdate struct{}
+
+func (stableUpdate) Boilerplate(codename string) string {
+	return ""
+}
+
+func (stableUpdate) Version(previous, codename string) (string, error) {
+	return stableUpdateVersion(previous, codename)
+}
+
+func (stableUpdate) Distribution(codename string) string {
+	return codename
+}
+
+func (stableUpdate) Stable() bool {
+	return true
+}
+
+// splitVersion splits version into the upstream version (including the
+// epoch, if any) and the Debian revision, which is empty for native
+// packages.
+func splitVersion(version string) (upstream, revision string) {
+	if idx := strings.LastIndex(version, "-"); idx > -1 {
+		return version[:idx], version[idx+1:]
+	}
+	return version, ""
+}
+
+var (
+	// uploadSuffixRe matches the version suffixes of NMUs of native
+	// packages, backports and stable updates.
+	uploadSuffixRe = regexp.MustCompile(`(\+nmu\d+|~bpo\d+\+\d+|\+deb\d+u\d+)$`)
+
+	// trailingNumberRe matches the last number of a version and what
+	// follows it.
+	trailingNumberRe = regexp.MustCompile(`(\d+)(\D*)$`)
+
+	leadingNumberRe = regexp.MustCompile(`^\d+`)
+)
+
+// incrementLast increments the last number in version, e.g. “1.1” for
+// “1.0”, or appends 1 if version does not contain a number.
+func incrementLast(version string) string {
+	matches := trailingNumberRe.FindStringSubmatchIndex(version)
+	if matches == nil {
+		return version + "1"
+	}
+	n, _ := strconv.Atoi(version[matches[2]:matches[3]])
+	return version[:matches[2]] + strconv.Itoa(n+1) + version[matches[3]:]
+}
+
+// nextVersion returns the version of a maintainer upload following
+// version by incrementing the Debian revision (or, for native packages,
+// the version) like dch --increment does, e.g. “2.31a-3” for “2.31a-2”
+// or “2.31a-1.1”, and “1.1” for “1.0”.
+func nextVersion(version string) string {
+	upstream, revision := splitVersion(version)
+	if revision == "" {
+		return incrementLast(uploadSuffixRe.ReplaceAllString(upstream, ""))
+	}
+	number := leadingNumberRe.FindString(revision)
+	if number == "" {
+		return version + "1"
+	}
+	n, _ := strconv.Atoi(number)
+	return fmt.Sprintf("%s-%d", upstream, n+1)
+}
+
+var (
+	nmuRevisionRe = regexp.MustCompile(`^\d+\.\d+$`)
+	nativeNMURe   = regexp.MustCompile(`\+nmu\d+$`)
+)
+
+// nmuVersion returns the version of a non-maintainer upload following
+// version, e.g. “2.31a-2.1” for “2.31a-2” and “1.0+nmu1” for the native
+// version “1.0”. Consecutive NMUs increment the NMU number.
+func nmuVersion(version string) string {
+	upstream, revision := splitVersion(version)
+	if revision == "" {
+		if nativeNMURe.MatchString(upstream) {
+			return incrementLast(upstream)
+		}
+		return upstream + "+nmu1"
+	}
+	if nmuRevisionRe.MatchString(revision) {
+		return incrementLast(version)
+	}
+	return version + ".1"
+}
+
+var backportSuffixRe = regexp.MustCompile(`~bpo(\d+)\+\d+$`)
+
+// backportVersion returns the version of a backport of version to the
+// stable release codename, e.g. “2.31a-2~bpo13+1” for “2.31a-2”.
+// Consecutive backports of the same version increment the backport
+// number.
+func backportVersion(version, codename string) (string, error) {
+	number, err := releaseNumber(codename)
+	if err != nil {
+		return "", err
+	}
+	if matches := backportSuffixRe.FindStringSubmatch(version); matches != nil {
+		if matches[1] == number {
+			return incrementLast(version), nil
+		}
+		version = strings.TrimSuffix(version, matches[0])
+	}
+	return version + "~bpo" + number + "+1", nil
+}
+
+var stableUpdateSuffixRe = regexp.MustCompile(`\+deb(\d+)u\d+$`)
+
+// stableUpdateVersion returns the version of a stable update of version
+// in the stable release codename, e.g. “2.31a-2+deb13u1” for “2.31a-2”.
+// Consecutive updates increment the update number.
+func stableUpdateVersion(version, codename string) (string, error) {
+	number, err := releaseNumber(codename)
+	if err != nil {
+		return "", err
+	}
+	if matches := stableUpdateSuffixRe.FindStringSubmatch(version); matches != nil {
+		if matches[1] == number {
+			return incrementLast(version), nil
+		}
+		version = strings.TrimSuffix(version, matches[0])
+	}
+	return version + "+deb" + number + "u1", nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// useDistroInfo diverts debian-distro-info with a shell script which
+// knows bookworm and trixie (the stable release), and returns a
+// function which undoes the diversion.
+func useDistroInfo(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "test-distro-info-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const diversion = `#!/bin/sh
+case "$*" in
+--stable) echo trixie ;;
+"--series=bookworm --release") echo 12 ;;
+"--series=trixie --release") echo 13 ;;
+"--series=sid --release") echo ;;
+*) echo "debian-distro-info: unknown distribution series" >&2; exit 1 ;;
+esac
+`
+	if err := ioutil.WriteFile(filepath.Join(dir, "debian-distro-info"), []byte(diversion), 0755); err != nil {
+		t.Fatal(err)
+	}
+	oldPath := os.Getenv("PATH")
+	os.Setenv("PATH", dir+":"+oldPath)
+	return func() {
+		os.Setenv("PATH", oldPath)
+		os.RemoveAll(dir)
+	}
+}
+
+func TestUploadVersions(t *testing.T) {
+	defer useDistroInfo(t)()
+
+	for _, tt := range []struct {
+		uploadType string
+		previous   string
+		want       string
+	}{
+		{"maintainer", "1.0", "1.1"},
+		{"maintainer", "2.31a-2", "2.31a-3"},
+		{"maintainer", "1:2.31a-9", "1:2.31a-10"},
+		{"maintainer", "2.31a-2.1", "2.31a-3"},
+		{"maintainer", "2.31a-2+deb13u1", "2.31a-3"},
+		{"maintainer", "1.0+nmu1", "1.1"},
+		{"maintainer", "1.0-alpha", "1.0-alpha1"},
+		{"team", "2.31a-2", "2.31a-3"},
+		{"qa", "2.31a-2", "2.31a-3"},
+		{"nmu", "2.31a-2", "2.31a-2.1"},
+		{"nmu", "2.31a-2.1", "2.31a-2.2"},
+		{"nmu", "1.0", "1.0+nmu1"},
+		{"nmu", "1.0+nmu1", "1.0+nmu2"},
+		{"bpo", "2.31a-2", "2.31a-2~bpo13+1"},
+		{"bpo", "2.31a-2~bpo13+1", "2.31a-2~bpo13+2"},
+		{"bpo", "2.31a-2~bpo12+3", "2.31a-2~bpo13+1"},
+		{"stable-update", "2.31a-2", "2.31a-2+deb13u1"},
+		{"stable-update", "2.31a-2+deb13u1", "2.31a-2+deb13u2"},
+		{"stable-update", "1.0", "1.0+deb13u1"},
+	} {
+		upload, err := lookupUploadType(tt.uploadType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := upload.Version(tt.previous, "trixie")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("%s upload after %q: got version %q, want %q", tt.uploadType, tt.previous, got, tt.want)
+		}
+	}
+
+	// Code names which distro-info does not know (e.g. due to a typo)
+	// must not result in a version suffix without number.
+	for _, uploadType := range []string{"bpo", "stable-update"} {
+		upload, err := lookupUploadType(uploadType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, codename := range []string{"trixy", "sid"} {
+			if got, err := upload.Version("2.31a-2", codename); err == nil {
+				t.Errorf("%s upload for %q: unexpectedly got version %q", uploadType, codename, got)
+			}
+		}
+	}
+}
+
+func TestUploadDistributions(t *testing.T) {
+	for _, tt := range []struct {
+		uploadType       string
+		wantDistribution string
+		wantBoilerplate  string
+	}{
+		{"maintainer", "unstable", ""},
+		{"team", "unstable", "Team upload."},
+		{"nmu", "unstable", "Non-maintainer upload."},
+		{"qa", "unstable", "QA upload."},
+		{"bpo", "trixie-backports", "Rebuild for trixie-backports."},
+		{"stable-update", "trixie", ""},
+	} {
+		upload, err := lookupUploadType(tt.uploadType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := upload.Distribution("trixie"); got != tt.wantDistribution {
+			t.Errorf("%s upload: got distribution %q, want %q", tt.uploadType, got, tt.wantDistribution)
+		}
+		if boilerplate := upload.Boilerplate("trixie"); boilerplate != tt.wantBoilerplate {
+			t.Errorf("%s upload: got boilerplate %q, want %q", tt.uploadType, boilerplate, tt.wantBoilerplate)
+		}
+	}
+
+	if _, err := lookupUploadType("binnmu"); err == nil {
+		t.Fatalf("lookupUploadType unexpectedly succeeded for an unknown upload type")
+	}
+}